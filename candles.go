@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/global"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"nhooyr.io/websocket"
+)
+
+// Candle series each /ws client follows
+type candleSubscriptions struct {
+	mu   sync.Mutex
+	subs map[*websocket.Conn]map[candleSeries]bool
+}
+
+type candleSeries struct {
+	id  uint
+	res candles.Resolution
+}
+
+var candleSubs = candleSubscriptions{subs: make(map[*websocket.Conn]map[candleSeries]bool)}
+
+func (s *candleSubscriptions) set(ws *websocket.Conn, series candleSeries, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[ws] == nil {
+		s.subs[ws] = make(map[candleSeries]bool)
+	}
+	if on {
+		s.subs[ws][series] = true
+	} else {
+		delete(s.subs[ws], series)
+	}
+}
+
+func (s *candleSubscriptions) drop(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ws)
+}
+
+func (s *candleSubscriptions) subscribers(series candleSeries) []*websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*websocket.Conn
+	for ws, subs := range s.subs {
+		if subs[series] {
+			out = append(out, ws)
+		}
+	}
+	return out
+}
+
+// Handles {"action": "subscribe_candles" or "unsubscribe_candles", "id": ..., "resolution": ...}
+func handleCandleMessage(ws *websocket.Conn, msg map[string]string) bool {
+	on := msg["action"] == "subscribe_candles"
+	if !on && msg["action"] != "unsubscribe_candles" {
+		return false
+	}
+	id, err := strconv.ParseUint(msg["id"], 10, 0)
+	res := candles.Resolution(msg["resolution"])
+	if err != nil || res.Validate() != nil {
+		return true
+	}
+	candleSubs.set(ws, candleSeries{uint(id), res}, on)
+	return true
+}
+
+func candleRoutes(e *echo.Echo) {
+	// The latest n candles of a stock, oldest first
+	e.GET("/candles", func(c echo.Context) error {
+		id, err := strconv.ParseUint(c.QueryParam("id"), 10, 0)
+		if err != nil {
+			return c.String(400, "Missing id")
+		}
+		res := candles.Resolution(c.QueryParam("resolution"))
+		if res == "" {
+			res = candles.Res1m
+		}
+		if err := res.Validate(); err != nil {
+			return c.String(400, err.Error())
+		}
+		n := 0
+		if q := c.QueryParam("n"); q != "" {
+			if n, err = strconv.Atoi(q); err != nil {
+				return c.String(400, "Failed to parse n")
+			}
+		}
+		return c.JSON(200, global.Candles.Series(uint(id), res, n))
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib/discovery"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/source"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// bursa discover: watches a sample of tickers and reports every message type and field seen
+func discover(args []string) {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	sample := flags.Int("sample", 50, "number of stocks to subscribe to")
+	duration := flags.Duration("duration", 5*time.Minute, "how long to watch the feed")
+	out := flags.String("out", ".", "directory to write catalogue.json and catalogue.md to")
+	_ = flags.Parse(args)
+
+	catalogue := discovery.New()
+	src := source.NewCyberstock(append(upstreamOptions(), ws.WithObserver(catalogue.Observe))...)
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	metadata, err := src.Metadata(ctx)
+	if err != nil {
+		panic(err)
+	}
+	ids := make([]uint, 0, len(metadata))
+	for id := range metadata {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	// Spread the sample across the whole list rather than the first few boards
+	step := max(len(ids)/max(*sample, 1), 1)
+	var sampled []uint
+	for i := 0; i < len(ids) && len(sampled) < *sample; i += step {
+		sampled = append(sampled, ids[i])
+	}
+	log.Printf("Watching %d stocks for %s", len(sampled), *duration)
+
+	stockCh := make(chan models.StockEntry, 100)
+	go func() {
+		for range stockCh {
+		}
+	}()
+	_ = src.Stream(ctx, sampled, stockCh)
+
+	for name, write := range map[string]func(*os.File) error{
+		"catalogue.json": func(f *os.File) error { return catalogue.WriteJSON(f) },
+		"catalogue.md":   func(f *os.File) error { return catalogue.WriteMarkdown(f) },
+	} {
+		f, err := os.Create(filepath.Join(*out, name))
+		if err != nil {
+			panic(err)
+		}
+		if err := write(f); err != nil {
+			panic(err)
+		}
+		f.Close()
+		log.Printf("Wrote %s", f.Name())
+	}
+}
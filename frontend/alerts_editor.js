@@ -12,6 +12,46 @@ class AlertsEditor extends HTMLElement {
       "buy_volume",
       "sell_volume",
       "buy_rate",
+      "price_change_ticks",
+      "ticks_to_target",
+      "candle_open",
+      "candle_high",
+      "candle_low",
+      "candle_close",
+      "candle_volume",
+      "candle_value",
+    ];
+    // Indicators and the default values of their arguments. They are
+    // written as calls like rsi(14, 5m)
+    this.indicators = {
+      sma: [20],
+      ema: [20],
+      rsi: [14],
+      macd: [12, 26, 9],
+      macd_signal: [12, 26, 9],
+      macd_histogram: [12, 26, 9],
+      bollinger_upper: [20, 2],
+      bollinger_middle: [20, 2],
+      bollinger_lower: [20, 2],
+      atr: [14],
+      vwap: [],
+      volume_avg: [20],
+    };
+    this.variables.push(...Object.keys(this.indicators));
+    this.resolutions = ["1m", "5m", "15m", "1h", "1d"];
+    this.boards = ["main", "ace", "leap", "etf"];
+    this.watchlists = {};
+    this.triggers = ["level", "edge", "cooldown", "session", "hysteresis"];
+    this.events = ["listing", "delisting", "name_change", "ticker_change"];
+    this.phases = [
+      "pre_open",
+      "morning",
+      "lunch",
+      "pre_open_afternoon",
+      "afternoon",
+      "pre_close",
+      "trading_at_last",
+      "closed",
     ];
   }
 
@@ -19,6 +59,7 @@ class AlertsEditor extends HTMLElement {
     this.render();
     this.addEventListeners();
     await this.fetchAlerts();
+    await this.fetchWatchlists();
     this.renderAlertsList();
   }
 
@@ -47,7 +88,7 @@ class AlertsEditor extends HTMLElement {
         label {
           margin-top: 10px;
         }
-        input, select {
+        input, select, textarea {
           margin-bottom: 10px;
         }
         .rule {
@@ -55,6 +96,16 @@ class AlertsEditor extends HTMLElement {
           padding: 10px;
           margin-bottom: 10px;
         }
+        .group {
+          border-left: 3px solid #ccc;
+          padding-left: 10px;
+          margin-bottom: 10px;
+        }
+        .events {
+          display: flex;
+          flex-wrap: wrap;
+          gap: 10px;
+        }
         .tags {
           display: flex;
           flex-wrap: wrap;
@@ -69,7 +120,9 @@ class AlertsEditor extends HTMLElement {
 			<h3>Alerts</h3>
       <div id="alerts-list"></div>
       <button id="add-alert">Add Alert</button>
+      <button id="edit-watchlists">Watchlists</button>
       <dialog id="alert-dialog"></dialog>
+      <dialog id="watchlist-dialog"></dialog>
     `;
   }
 
@@ -77,6 +130,111 @@ class AlertsEditor extends HTMLElement {
     this.shadowRoot
       .querySelector("#add-alert")
       .addEventListener("click", () => this.openAlertDialog());
+    this.shadowRoot
+      .querySelector("#edit-watchlists")
+      .addEventListener("click", () => this.openWatchlistDialog());
+  }
+
+  async fetchWatchlists() {
+    const resp = await fetch("/watchlists/");
+    if (resp.status !== 200) {
+      alert(await resp.text());
+      return;
+    }
+    this.watchlists = (await resp.json()) || {};
+  }
+
+  // One watchlist per line, written as name: stock, stock
+  openWatchlistDialog() {
+    const dialog = this.shadowRoot.querySelector("#watchlist-dialog");
+    const lines = Object.entries(this.watchlists)
+      .map(([name, stocks]) => `${name}: ${stocks.join(", ")}`)
+      .join("\n");
+    dialog.innerHTML = `
+      <form id="watchlist-form">
+        <label for="watchlists">Watchlists, one per line as name: ticker, ticker:</label>
+        <textarea id="watchlists" name="watchlists" rows="8" placeholder="banks: MAYBANK, PBBANK, CIMB">${lines}</textarea>
+        <button type="submit">Save</button>
+        <button type="button" id="cancel-watchlists">Cancel</button>
+      </form>
+    `;
+    dialog.querySelector("#watchlist-form").addEventListener("submit", (e) => {
+      e.preventDefault();
+      this.saveWatchlists(dialog.querySelector("#watchlists").value);
+    });
+    dialog
+      .querySelector("#cancel-watchlists")
+      .addEventListener("click", () => dialog.close());
+    dialog.showModal();
+  }
+
+  async saveWatchlists(text) {
+    const lists = {};
+    for (const line of text.split("\n")) {
+      const at = line.indexOf(":");
+      if (at < 0) continue;
+      const name = line.slice(0, at).trim();
+      if (name) lists[name] = this.splitList(line.slice(at + 1));
+    }
+    const requests = [
+      ...Object.entries(lists).map(([name, stocks]) => [
+        name,
+        { method: "PUT", body: JSON.stringify(stocks) },
+      ]),
+      ...Object.keys(this.watchlists)
+        .filter((name) => !(name in lists))
+        .map((name) => [name, { method: "DELETE" }]),
+    ];
+    for (const [name, init] of requests) {
+      const resp = await fetch(`/watchlists/${encodeURIComponent(name)}`, {
+        headers: { "Content-Type": "application/json" },
+        ...init,
+      });
+      if (resp.status !== 200) {
+        alert(await resp.text());
+        break;
+      }
+    }
+    await this.fetchWatchlists();
+    this.shadowRoot.querySelector("#watchlist-dialog").close();
+  }
+
+  splitList(text) {
+    return text
+      .split(",")
+      .map((item) => item.trim())
+      .filter((item) => item);
+  }
+
+  // Inputs for the stocks a scope selects, or with the exclude prefix those
+  // it leaves out
+  renderSelector(prefix, selector) {
+    const sel = selector || {};
+    const list = (field) => (sel[field] ? sel[field].join(", ") : "");
+    return `
+      <label>Tickers or names: <input type="text" name="${prefix}-tickers" value="${list("tickers")}" placeholder="MAYBANK, 1295"></label>
+      <label>Ids: <input type="text" name="${prefix}-ids" value="${list("ids")}"></label>
+      <label>Prefixes: <input type="text" name="${prefix}-prefixes" value="${list("prefixes")}"></label>
+      <label>Patterns (one per line):</label>
+      <textarea name="${prefix}-patterns" rows="2">${sel.patterns ? sel.patterns.join("\n") : ""}</textarea>
+      <span title="Needs boards.yaml in the config dir">Boards:</span>
+      <div class="events">
+        ${this.boards
+          .map(
+            (b) => `
+        <label><input type="checkbox" name="${prefix}-boards" value="${b}" ${sel.boards && sel.boards.includes(b) ? "checked" : ""}> ${b.toUpperCase()}</label>`,
+          )
+          .join("")}
+      </div>
+      <div class="events">
+        ${Object.keys(this.watchlists)
+          .map(
+            (w) => `
+        <label><input type="checkbox" name="${prefix}-watchlists" value="${w}" ${sel.watchlists && sel.watchlists.includes(w) ? "checked" : ""}> ${w}</label>`,
+          )
+          .join("")}
+      </div>
+    `;
   }
 
   async fetchAlerts() {
@@ -97,6 +255,7 @@ class AlertsEditor extends HTMLElement {
   openAlertDialog(index) {
     const dialog = this.shadowRoot.querySelector("#alert-dialog");
     const alert = index !== undefined ? this.alerts[index] : null;
+    this.ruleIndex = 0;
 
     dialog.innerHTML = `
       <form id="alert-form">
@@ -106,11 +265,53 @@ class AlertsEditor extends HTMLElement {
         <label for="tags">Tags (comma-separated):</label>
         <input type="text" id="tags" name="tags" value="${alert ? (alert.tags ? alert.tags.join(",") : "") : ""}">
         
+        <h3>Events:</h3>
+        <div class="events">
+          ${this.events
+            .map(
+              (e) => `
+          <label><input type="checkbox" name="events" value="${e}" ${alert && alert.events && alert.events.includes(e) ? "checked" : ""}> ${e.replace("_", " ")}</label>`,
+            )
+            .join("")}
+        </div>
+
+        <h3>Phases (none for all):</h3>
+        <div class="events">
+          ${this.phases
+            .map(
+              (p) => `
+          <label><input type="checkbox" name="phases" value="${p}" ${alert && alert.phases && alert.phases.includes(p) ? "checked" : ""}> ${p.replaceAll("_", " ")}</label>`,
+            )
+            .join("")}
+        </div>
+
+        <h3>Scope (none for all stocks):</h3>
+        ${this.renderSelector("scope", alert && alert.scope)}
+        <h4>Except:</h4>
+        ${this.renderSelector("exclude", alert && alert.scope && alert.scope.exclude)}
+
         <h3>Rules:</h3>
         <div id="rules-container">
-          ${alert ? this.renderRules(alert.rules) : ""}
+          ${this.renderGroup(this.rootGroup(alert), true)}
+        </div>
+
+        <label for="expr">Expression (must hold as well as the rules):</label>
+        <textarea id="expr" name="expr" rows="3" placeholder="last_price > 1.05 * preclose_price and (buy_rate >= 0.6 or rsi(14, 5m) < 30)">${alert && alert.expr ? alert.expr : ""}</textarea>
+
+        <h3>Trigger:</h3>
+        <div class="trigger">
+          <select name="trigger-mode">
+            ${this.triggers
+              .map(
+                (m) => `
+            <option value="${m}" ${((alert && alert.trigger && alert.trigger.mode) || "level") === m ? "selected" : ""}>${m}</option>`,
+              )
+              .join("")}
+          </select>
+          <label>Cooldown (minutes): <input type="number" name="trigger-cooldown" min="0" value="${alert && alert.trigger && alert.trigger.cooldown ? alert.trigger.cooldown : ""}"></label>
+          <label>Band (%): <input type="number" name="trigger-band" min="0" max="99" step="any" value="${alert && alert.trigger && alert.trigger.band ? alert.trigger.band * 100 : ""}"></label>
+          <label>Re-arm after (minutes): <input type="number" name="trigger-rearm" min="0" value="${alert && alert.trigger && alert.trigger.rearm ? alert.trigger.rearm : ""}"></label>
         </div>
-        <button type="button" id="add-rule">Add Rule</button>
         
         <button type="submit">Save</button>
         <button type="button" id="cancel">Cancel</button>
@@ -125,10 +326,29 @@ class AlertsEditor extends HTMLElement {
     dialog
       .querySelector("#cancel")
       .addEventListener("click", () => dialog.close());
-    dialog
-      .querySelector("#add-rule")
-      .addEventListener("click", () => this.addRule());
+    dialog.querySelector("#rules-container").addEventListener("click", (e) => {
+      const group = e.target.closest(".group");
+      if (e.target.classList.contains("add-rule")) {
+        group
+          .querySelector(":scope > .children")
+          .insertAdjacentHTML("beforeend", this.renderRule(this.blankRule()));
+      } else if (e.target.classList.contains("add-group")) {
+        group
+          .querySelector(":scope > .children")
+          .insertAdjacentHTML("beforeend", this.renderGroup({ all: [] }));
+      } else if (e.target.classList.contains("remove-node")) {
+        e.target.closest(".rule, .group").remove();
+      }
+    });
     dialog.addEventListener("change", (e) => {
+      if (e.target.classList.contains("var-select")) {
+        const { index, side } = e.target.dataset;
+        e.target.closest(".var-input").outerHTML = this.renderValueInput(side, index, {
+          type: "var",
+          value: e.target.value,
+        });
+        return;
+      }
       if (e.target.classList.contains("type-select")) {
         const { index, side } = e.target.dataset;
         const valueContainer = e.target.nextElementSibling;
@@ -142,12 +362,49 @@ class AlertsEditor extends HTMLElement {
     dialog.showModal();
   }
 
-  renderRules(rules) {
-    if (!rules) return "";
-    return rules
-      .map(
-        (rule, index) => `
-    <div class="rule">
+  // Alerts saved as a flat list of rules edit as a single all group
+  rootGroup(alert) {
+    if (alert && alert.when) {
+      return alert.when.rule ? { all: [alert.when] } : alert.when;
+    }
+    return { all: (alert && alert.rules ? alert.rules : []).map((rule) => ({ rule })) };
+  }
+
+  renderNode(node) {
+    return node.rule ? this.renderRule(node.rule) : this.renderGroup(node);
+  }
+
+  renderGroup(group, root = false) {
+    const op = group.any ? "any" : group.not ? "not" : "all";
+    const children = group.not ? [group.not] : group[op] || [];
+    return `
+    <div class="group">
+      <select class="group-op">
+        <option value="all" ${op === "all" ? "selected" : ""}>All of</option>
+        <option value="any" ${op === "any" ? "selected" : ""}>Any of</option>
+        <option value="not" ${op === "not" ? "selected" : ""}>None of</option>
+      </select>
+      <div class="children">
+        ${children.map((child) => this.renderNode(child)).join("")}
+      </div>
+      <button type="button" class="add-rule">Add Rule</button>
+      <button type="button" class="add-group">Add Group</button>
+      ${root ? "" : `<button type="button" class="remove-node">Remove</button>`}
+    </div>`;
+  }
+
+  blankRule() {
+    return {
+      a: { type: "var", value: "" },
+      cmp: "==",
+      b: { type: "const", value: "" },
+    };
+  }
+
+  renderRule(rule) {
+    const index = this.ruleIndex++;
+    return `
+    <div class="rule" data-index="${index}">
       <select name="a-type-${index}" class="type-select" data-index="${index}" data-side="a">
         <option value="var" ${rule.a.type === "var" ? "selected" : ""}>Variable</option>
         <option value="const" ${rule.a.type === "const" ? "selected" : ""}>Constant</option>
@@ -161,6 +418,8 @@ class AlertsEditor extends HTMLElement {
         <option value=">=" ${rule.cmp === ">=" ? "selected" : ""}>>=</option>
         <option value="<" ${rule.cmp === "<" ? "selected" : ""}><</option>
         <option value="<=" ${rule.cmp === "<=" ? "selected" : ""}><=</option>
+        <option value="crosses_above" ${rule.cmp === "crosses_above" ? "selected" : ""}>crosses above</option>
+        <option value="crosses_below" ${rule.cmp === "crosses_below" ? "selected" : ""}>crosses below</option>
       </select>
       
       <select name="b-type-${index}" class="type-select" data-index="${index}" data-side="b">
@@ -168,47 +427,72 @@ class AlertsEditor extends HTMLElement {
         <option value="const" ${rule.b.type === "const" ? "selected" : ""}>Constant</option>
       </select>
       ${this.renderValueInput("b", index, rule.b)}
+
+      <label>Held for <input type="number" min="0" name="for-${index}" value="${rule.for || ""}"> minutes</label>
+      <label>At least <input type="number" min="0" name="times-${index}" value="${rule.times || ""}"> times in the last <input type="number" min="0" name="within-${index}" value="${rule.within || ""}"> minutes</label>
       
-      <button type="button" class="remove-rule" data-index="${index}">Remove</button>
+      <button type="button" class="remove-node">Remove</button>
     </div>
-  `,
-      )
-      .join("");
+  `;
   }
 
   renderValueInput(side, index, data) {
     if (data.type === "var") {
+      // Variables with parameters arrive as {type, duration, target, ...}
+      const spec =
+        typeof data.value === "object" && data.value !== null
+          ? data.value
+          : this.parseIndicator(data.value) ?? { type: data.value };
       return `
-      <select name="${side}-value-${index}">
-        ${this.variables.map((v) => `<option value="${v}" ${data.value === v ? "selected" : ""}>${v}</option>`).join("")}
+      <span class="var-input">
+      <select name="${side}-value-${index}" class="var-select" data-index="${index}" data-side="${side}">
+        ${this.variables.map((v) => `<option value="${v}" ${spec.type === v ? "selected" : ""}>${v}</option>`).join("")}
       </select>
+      ${this.renderParams(side, index, spec)}
+      </span>
     `;
     }
     return `<input type="text" name="${side}-value-${index}" value="${data.value}" required>`;
   }
-  addRule() {
-    const rulesContainer = this.shadowRoot.querySelector("#rules-container");
-    const newRuleIndex = rulesContainer.children.length;
-    const newRuleHtml = this.renderRules([
-      {
-        a: { type: "var", value: "" },
-        cmp: "==",
-        b: { type: "const", value: "" },
-      },
-    ]);
-    rulesContainer.insertAdjacentHTML("beforeend", newRuleHtml);
-
-    // Add event listener for the new remove button
-    const newRemoveButton =
-      rulesContainer.lastElementChild.querySelector(".remove-rule");
-    newRemoveButton.addEventListener("click", () =>
-      this.removeRule(newRuleIndex),
-    );
+  parseIndicator(value) {
+    const match = /^(\w+)\((.*)\)$/.exec(value ?? "");
+    if (!match || !(match[1] in this.indicators)) return null;
+    const args = match[2].split(",").map((arg) => arg.trim());
+    return { type: match[1], resolution: args.pop(), args };
   }
 
-  removeRule(index) {
-    const rulesContainer = this.shadowRoot.querySelector("#rules-container");
-    rulesContainer.children[index].remove();
+  renderResolution(side, index, resolution) {
+    return `
+      <select name="${side}-resolution-${index}">
+        ${this.resolutions.map((r) => `<option value="${r}" ${resolution === r ? "selected" : ""}>${r}</option>`).join("")}
+      </select>`;
+  }
+
+  renderParams(side, index, spec) {
+    const name = spec.type || "";
+    if (name in this.indicators) {
+      const args = spec.args ?? this.indicators[name];
+      return `
+      ${this.indicators[name].map((_, j) => `<input type="number" min="0" step="any" name="${side}-arg-${index}-${j}" value="${args[j]}" required>`).join("")}
+      ${this.renderResolution(side, index, spec.resolution)}`;
+    }
+    if (name === "ticks_to_target") {
+      return `<input type="text" name="${side}-target-${index}" value="${spec.target ?? ""}" placeholder="Target price" required>`;
+    }
+    if (name.startsWith("candle_")) {
+      return `
+      ${this.renderResolution(side, index, spec.resolution)}
+      <input type="number" min="0" name="${side}-offset-${index}" value="${spec.offset ?? 0}" title="Bars back">`;
+    }
+    // Entry variables read the oldest value within the duration, or how
+    // much they changed over it
+    return `
+      <input type="number" min="0" name="${side}-duration-${index}" value="${spec.duration || 0}" title="Minutes ago">
+      <select name="${side}-change-${index}">
+        <option value="" ${!spec.change ? "selected" : ""}>value</option>
+        <option value="absolute" ${spec.change === "absolute" ? "selected" : ""}>change</option>
+        <option value="percent" ${spec.change === "percent" ? "selected" : ""}>% change</option>
+      </select>`;
   }
 
   async saveAlert(index) {
@@ -222,24 +506,101 @@ class AlertsEditor extends HTMLElement {
         .split(",")
         .map((tag) => tag.trim())
         .filter((tag) => tag),
-      rules: [],
+      events: formData.getAll("events"),
+      phases: formData.getAll("phases"),
     };
+    const expr = formData.get("expr").trim();
+    if (expr) newAlert.expr = expr;
+    const selector = (prefix) => {
+      const sel = {
+        tickers: this.splitList(formData.get(`${prefix}-tickers`)),
+        ids: this.splitList(formData.get(`${prefix}-ids`)).map(Number),
+        prefixes: this.splitList(formData.get(`${prefix}-prefixes`)),
+        patterns: formData
+          .get(`${prefix}-patterns`)
+          .split("\n")
+          .filter((p) => p.trim()),
+        boards: formData.getAll(`${prefix}-boards`),
+        watchlists: formData.getAll(`${prefix}-watchlists`),
+      };
+      for (const field in sel) {
+        if (sel[field].length === 0) delete sel[field];
+      }
+      return sel;
+    };
+    const scope = selector("scope");
+    const exclude = selector("exclude");
+    if (Object.keys(exclude).length > 0) scope.exclude = exclude;
+    if (Object.keys(scope).length > 0) newAlert.scope = scope;
+    const mode = formData.get("trigger-mode");
+    if (mode !== "level") {
+      newAlert.trigger = { mode };
+      if (mode === "cooldown") {
+        newAlert.trigger.cooldown = Number(formData.get("trigger-cooldown"));
+      } else if (mode === "hysteresis") {
+        newAlert.trigger.band = Number(formData.get("trigger-band")) / 100;
+        newAlert.trigger.rearm = Number(formData.get("trigger-rearm"));
+      }
+    }
 
-    // Collect rules
-    const ruleElements = this.shadowRoot.querySelectorAll(".rule");
-    ruleElements.forEach((_, i) => {
-      newAlert.rules.push({
-        a: {
-          type: formData.get(`a-type-${i}`),
-          value: formData.get(`a-value-${i}`),
-        },
-        cmp: formData.get(`cmp-${i}`),
-        b: {
-          type: formData.get(`b-type-${i}`),
-          value: formData.get(`b-value-${i}`),
-        },
-      });
-    });
+    // Collect the rule tree
+    const operand = (side, i) => {
+      const value = formData.get(`${side}-value-${i}`);
+      if (value in this.indicators) {
+        const args = this.indicators[value].map((_, j) =>
+          formData.get(`${side}-arg-${i}-${j}`),
+        );
+        args.push(formData.get(`${side}-resolution-${i}`));
+        return {
+          type: formData.get(`${side}-type-${i}`),
+          value: `${value}(${args.join(", ")})`,
+        };
+      }
+      const spec = { type: value };
+      const target = formData.get(`${side}-target-${i}`);
+      if (target) spec.target = target;
+      const duration = Number(formData.get(`${side}-duration-${i}`) || 0);
+      if (duration) spec.duration = duration;
+      const change = formData.get(`${side}-change-${i}`);
+      if (change) spec.change = change;
+      const resolution = formData.get(`${side}-resolution-${i}`);
+      if (resolution) {
+        spec.resolution = resolution;
+        spec.offset = Number(formData.get(`${side}-offset-${i}`) || 0);
+      }
+      return {
+        type: formData.get(`${side}-type-${i}`),
+        value: Object.keys(spec).length > 1 ? spec : value,
+      };
+    };
+    const collect = (el) => {
+      if (el.classList.contains("rule")) {
+        const i = el.dataset.index;
+        const rule = {
+          a: operand("a", i),
+          cmp: formData.get(`cmp-${i}`),
+          b: operand("b", i),
+        };
+        for (const field of ["for", "times", "within"]) {
+          const n = Number(formData.get(`${field}-${i}`) || 0);
+          if (n) rule[field] = n;
+        }
+        return { rule };
+      }
+      const op = el.querySelector(":scope > .group-op").value;
+      const children = [
+        ...el.querySelector(":scope > .children").children,
+      ].map(collect);
+      // None of several children is not any of them
+      if (op === "not") {
+        return { not: children.length === 1 ? children[0] : { any: children } };
+      }
+      return { [op]: children };
+    };
+    const root = collect(
+      this.shadowRoot.querySelector("#rules-container > .group"),
+    );
+    if (!(root.all && root.all.length === 0)) newAlert.when = root;
 
     // Here you would typically send this data to your server
     const resp = await fetch("/alerts/" + (index ? "?id=" + index : ""), {
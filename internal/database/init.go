@@ -3,6 +3,7 @@ package database
 import (
 	"bursa-alert/lib/alerts"
 	"encoding/gob"
+	"fmt"
 	"os"
 )
 
@@ -35,6 +36,17 @@ func LoadAlerts() []alerts.Alert {
 		os.Remove(cfgPath())
 		return []alerts.Alert{}
 	}
+	// Labels were not always unique, but trigger states are kept by label
+	taken := make(map[string]bool, len(al))
+	for i := range al {
+		al[i].Migrate()
+		al[i].Upgrade()
+		label := al[i].Label
+		for n := 2; taken[label]; n++ {
+			label = fmt.Sprintf("%s (%d)", al[i].Label, n)
+		}
+		al[i].Label, taken[label] = label, true
+	}
 	return al
 }
 
@@ -45,6 +57,9 @@ func SaveAlerts(al []alerts.Alert) {
 		panic(err)
 	}
 	defer f.Close()
+	for i := range al {
+		al[i].Version = alerts.Version
+	}
 	enc := gob.NewEncoder(f)
 	err = enc.Encode(al)
 	if err != nil {
@@ -53,10 +68,15 @@ func SaveAlerts(al []alerts.Alert) {
 }
 
 func cfgPath() string {
+	return ConfigDir() + "/alerts.gob"
+}
+
+// Directory holding the saved alerts and other local state
+func ConfigDir() string {
 	// Get cross platform config path
 	cfgDir, err := os.UserConfigDir()
 	if err != nil {
 		panic(err)
 	}
-	return cfgDir + "/bursa/" + "alerts.gob"
+	return cfgDir + "/bursa"
 }
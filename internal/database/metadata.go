@@ -0,0 +1,46 @@
+package database
+
+import (
+	"bursa-alert/lib/models"
+	"encoding/gob"
+	"os"
+	"time"
+)
+
+// Stock metadata as last fetched from upstream
+type MetadataCache struct {
+	Stocks  map[uint]models.StockMetadata
+	Updated time.Time
+}
+
+func LoadMetadata() (MetadataCache, error) {
+	var c MetadataCache
+	f, err := os.Open(metadataPath())
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&c)
+	return c, err
+}
+
+// Writes the cache to a temporary file first so a crash never leaves it truncated
+func SaveMetadata(c MetadataCache) error {
+	tmp := metadataPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metadataPath())
+}
+
+func metadataPath() string {
+	return ConfigDir() + "/metadata.gob"
+}
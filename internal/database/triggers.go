@@ -0,0 +1,39 @@
+package database
+
+import (
+	"bursa-alert/lib/alerts"
+	"encoding/gob"
+	"os"
+)
+
+func LoadTriggers() (map[alerts.TriggerKey]alerts.TriggerState, error) {
+	var states map[alerts.TriggerKey]alerts.TriggerState
+	f, err := os.Open(triggersPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&states)
+	return states, err
+}
+
+// Written like the metadata cache, through a temporary file
+func SaveTriggers(states map[alerts.TriggerKey]alerts.TriggerState) error {
+	tmp := triggersPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(states); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, triggersPath())
+}
+
+func triggersPath() string {
+	return ConfigDir() + "/triggers.gob"
+}
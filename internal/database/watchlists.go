@@ -0,0 +1,37 @@
+package database
+
+import (
+	"encoding/gob"
+	"os"
+)
+
+func LoadWatchlists() (map[string][]string, error) {
+	var lists map[string][]string
+	f, err := os.Open(watchlistsPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&lists)
+	return lists, err
+}
+
+func SaveWatchlists(lists map[string][]string) error {
+	tmp := watchlistsPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(lists); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, watchlistsPath())
+}
+
+func watchlistsPath() string {
+	return ConfigDir() + "/watchlists.gob"
+}
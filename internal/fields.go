@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Field codes of the numeric cyberstock protocol. Codes named after their
+// message type have no known meaning and are sent as the dashboard does
+const (
+	FieldStockIndex          = 1
+	FieldTickers             = 2
+	FieldOffset              = 4
+	FieldLoginHash           = 14
+	FieldSessionNumber       = 16
+	FieldRS20                = 20
+	FieldRS21                = 21
+	FieldLogin37             = 37
+	FieldRF42                = 42
+	FieldSS44                = 44
+	FieldSS45                = 45
+	FieldSS46                = 46
+	FieldSS47                = 47
+	FieldRS52                = 52
+	FieldRS60                = 60
+	FieldLoginVersion        = 64
+	FieldLoginYear           = 65
+	FieldFlag66              = 66
+	FieldRS67                = 67
+	FieldRS69                = 69
+	FieldNames               = 77
+	FieldBuyValue            = 87
+	FieldAccumulatedValue    = 90
+	FieldBuyVolumeMorning    = 114
+	FieldSellVolumeMorning   = 117
+	FieldBuyVolumeAfternoon  = 120
+	FieldSellVolumeAfternoon = 123
+	FieldPreclosePrice       = 153
+	FieldLastPrice           = 209
+	FieldTotalBoughtQuantity = 210
+	FieldSubscriptionCount   = 229
+	FieldIsPurchase          = 248
+	FieldLoginToken          = 271
+	FieldSS316               = 316
+)
+
+type FieldType string
+
+const (
+	TypeInt    FieldType = "int"
+	TypeFloat  FieldType = "float"
+	TypeString FieldType = "string"
+	TypeList   FieldType = "list"
+)
+
+type Unit string
+
+const (
+	UnitNone Unit = ""
+	// Thousandths of a ringgit
+	UnitMilicents Unit = "milicents"
+	// Board lots of 100 shares
+	UnitLots Unit = "lots"
+	// 1 for true
+	UnitFlag Unit = "flag"
+)
+
+type Field struct {
+	Code     int       `json:"code"`
+	Name     string    `json:"name"`
+	Type     FieldType `json:"type"`
+	Unit     Unit      `json:"unit"`
+	Messages []string  `json:"messages"`
+}
+
+var Fields = registry(
+	Field{FieldStockIndex, "stock_index", TypeInt, UnitNone, []string{"MT", "SM", "RS"}},
+	Field{FieldTickers, "tickers", TypeList, UnitNone, []string{"SL"}},
+	Field{FieldOffset, "offset", TypeInt, UnitNone, []string{"SL"}},
+	Field{FieldLoginHash, "login_hash", TypeString, UnitNone, []string{"LG"}},
+	Field{FieldSessionNumber, "session_number", TypeString, UnitNone, []string{"LG"}},
+	Field{FieldRS20, "rs_20", TypeInt, UnitNone, []string{"RS"}},
+	Field{FieldRS21, "rs_21", TypeList, UnitNone, []string{"RS"}},
+	Field{FieldLogin37, "login_37", TypeInt, UnitNone, []string{"LG"}},
+	Field{FieldRF42, "rf_42", TypeInt, UnitNone, []string{"RF"}},
+	Field{FieldSS44, "ss_44", TypeInt, UnitNone, []string{"SS"}},
+	Field{FieldSS45, "ss_45", TypeInt, UnitNone, []string{"SS"}},
+	Field{FieldSS46, "ss_46", TypeInt, UnitNone, []string{"SS"}},
+	Field{FieldSS47, "ss_47", TypeInt, UnitNone, []string{"SS"}},
+	Field{FieldRS52, "rs_52", TypeInt, UnitNone, []string{"RS"}},
+	Field{FieldRS60, "rs_60", TypeList, UnitNone, []string{"RS"}},
+	Field{FieldLoginVersion, "login_version", TypeInt, UnitNone, []string{"LG"}},
+	Field{FieldLoginYear, "login_year", TypeInt, UnitNone, []string{"LG"}},
+	Field{FieldFlag66, "flag_66", TypeInt, UnitFlag, []string{"RS", "SS"}},
+	Field{FieldRS67, "rs_67", TypeInt, UnitNone, []string{"RS"}},
+	Field{FieldRS69, "rs_69", TypeList, UnitNone, []string{"RS"}},
+	Field{FieldNames, "names", TypeList, UnitNone, []string{"SL"}},
+	Field{FieldBuyValue, "buy_value", TypeInt, UnitMilicents, []string{"SM"}},
+	Field{FieldAccumulatedValue, "accumulated_value", TypeFloat, UnitNone, []string{"SM"}},
+	Field{FieldBuyVolumeMorning, "buy_volume_morning", TypeInt, UnitLots, []string{"SM"}},
+	Field{FieldSellVolumeMorning, "sell_volume_morning", TypeInt, UnitLots, []string{"SM"}},
+	Field{FieldBuyVolumeAfternoon, "buy_volume_afternoon", TypeInt, UnitLots, []string{"SM"}},
+	Field{FieldSellVolumeAfternoon, "sell_volume_afternoon", TypeInt, UnitLots, []string{"SM"}},
+	Field{FieldPreclosePrice, "preclose_price", TypeInt, UnitMilicents, []string{"MT"}},
+	Field{FieldLastPrice, "last_price", TypeInt, UnitMilicents, []string{"MT"}},
+	Field{FieldTotalBoughtQuantity, "total_bought_quantity", TypeInt, UnitLots, []string{"MT"}},
+	Field{FieldSubscriptionCount, "subscription_count", TypeInt, UnitNone, []string{"RS"}},
+	Field{FieldIsPurchase, "is_purchase", TypeInt, UnitFlag, []string{"MT"}},
+	Field{FieldLoginToken, "login_token", TypeString, UnitNone, []string{"LG"}},
+	Field{FieldSS316, "ss_316", TypeInt, UnitNone, []string{"SS"}},
+)
+
+func registry(fields ...Field) map[int]Field {
+	m := make(map[int]Field, len(fields))
+	for _, f := range fields {
+		m[f.Code] = f
+	}
+	return m
+}
+
+// JSON key of a field code
+func Key(code int) string {
+	return strconv.Itoa(code)
+}
+
+// Builds a message data object from field codes
+func Encode(fields map[int]any) map[string]any {
+	m := make(map[string]any, len(fields))
+	for code, v := range fields {
+		m[Key(code)] = v
+	}
+	return m
+}
+
+// Raw field values of a message data object keyed by code
+type FieldValues map[int]json.RawMessage
+
+// Splits a data object into fields. Codes the registry does not know for mt
+// are recorded in Unknown
+func DecodeFields(mt string, b []byte) (FieldValues, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	values := make(FieldValues, len(raw))
+	for k, v := range raw {
+		code, err := strconv.Atoi(k)
+		if err != nil {
+			Unknown.Record(mt, k, v)
+			continue
+		}
+		if f, ok := Fields[code]; !ok || !slices.Contains(f.Messages, mt) {
+			Unknown.Record(mt, k, v)
+		}
+		values[code] = v
+	}
+	return values, nil
+}
+
+// Decodes the field into v. Absent fields leave v untouched
+func (fv FieldValues) Get(code int, v any) error {
+	raw, ok := fv[code]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+type UnknownField struct {
+	Count int `json:"count"`
+	// A recent value. Replaced as the count reaches each power of two
+	Example json.RawMessage `json:"example"`
+}
+
+// Fields seen on the wire that are not in the registry, by message type and
+// key. Recording takes no lock once a field has been seen, so shards do not
+// contend on it
+type UnknownFields struct {
+	fields sync.Map
+}
+
+type unknownKey struct {
+	mt, key string
+}
+
+type unknownCounter struct {
+	count   atomic.Int64
+	example atomic.Pointer[json.RawMessage]
+}
+
+var Unknown = &UnknownFields{}
+
+func (u *UnknownFields) Record(mt, key string, v json.RawMessage) {
+	k := unknownKey{mt, key}
+	c, ok := u.fields.Load(k)
+	if !ok {
+		c, _ = u.fields.LoadOrStore(k, new(unknownCounter))
+	}
+	counter := c.(*unknownCounter)
+	if n := counter.count.Add(1); n&(n-1) == 0 {
+		example := append(json.RawMessage(nil), v...)
+		counter.example.Store(&example)
+	}
+}
+
+func (u *UnknownFields) Snapshot() map[string]map[string]UnknownField {
+	m := make(map[string]map[string]UnknownField)
+	u.fields.Range(func(k, c any) bool {
+		key, counter := k.(unknownKey), c.(*unknownCounter)
+		if m[key.mt] == nil {
+			m[key.mt] = make(map[string]UnknownField)
+		}
+		f := UnknownField{Count: int(counter.count.Load())}
+		if example := counter.example.Load(); example != nil {
+			f.Example = *example
+		}
+		m[key.mt][key.key] = f
+		return true
+	})
+	return m
+}
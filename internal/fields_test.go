@@ -0,0 +1,92 @@
+package internal_test
+
+import (
+	"bursa-alert/internal"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestStockEntryFieldsRegistered(t *testing.T) {
+	typ := reflect.TypeOf(internal.StockEntry{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("json")
+		code, err := strconv.Atoi(tag)
+		if err != nil {
+			continue
+		}
+		f, ok := internal.Fields[code]
+		if !ok {
+			t.Errorf("field %s (%d) is not registered", typ.Field(i).Name, code)
+			continue
+		}
+		if !slices.Contains(f.Messages, "MT") && !slices.Contains(f.Messages, "SM") {
+			t.Errorf("field %s (%d) is not registered for MT or SM", typ.Field(i).Name, code)
+		}
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	fields, err := internal.DecodeFields("MT", []byte(`{"1": 3, "209": 1000, "999": [1, 2], "87": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 4 {
+		t.Errorf("unknown fields were dropped: %v", fields)
+	}
+	unknown := internal.Unknown.Snapshot()["MT"]
+	if f := unknown["999"]; f.Count != 1 || string(f.Example) != "[1, 2]" {
+		t.Errorf("unknown code not collected: %+v", unknown)
+	}
+	// Known codes in an unexpected message type are also collected
+	if f := unknown["87"]; f.Count != 1 {
+		t.Errorf("misplaced code not collected: %+v", unknown)
+	}
+	if _, ok := unknown["209"]; ok {
+		t.Error("known code collected as unknown")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	entry := internal.StockEntry{StockIndex: 1, LastPrice: 1000, BuyValue: 5}
+	fields, err := internal.DecodeFields("MT", []byte(`{"1": 1, "209": 1010, "90": 2.5, "87": 0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Merge(fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := internal.StockEntry{StockIndex: 1, LastPrice: 1010, AccumulatedValue: 2.5}
+	if entry != expected {
+		t.Errorf("merged %+v, expected %+v", entry, expected)
+	}
+
+	// Every tagged field is stored
+	typ := reflect.TypeOf(internal.StockEntry{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("json")
+		if _, err := strconv.Atoi(tag); err != nil {
+			continue
+		}
+		var e internal.StockEntry
+		if err := e.Merge(internal.FieldValues{mustAtoi(tag): []byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+		if reflect.ValueOf(e).Field(i).IsZero() {
+			t.Errorf("field %s (%s) was not merged", typ.Field(i).Name, tag)
+		}
+	}
+
+	if err := entry.Merge(internal.FieldValues{209: []byte(`"1.01"`)}); err == nil {
+		t.Error("merged a string into an int field")
+	}
+}
+
+func mustAtoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
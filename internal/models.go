@@ -1,5 +1,11 @@
 package internal
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
 type StockEntry struct {
 	StockIndex uint `json:"1"`
 	// -- MT Data
@@ -21,3 +27,54 @@ type StockEntry struct {
 	SellVolumeMorning   uint `json:"117"`
 	SellVolumeAfternoon uint `json:"123"`
 }
+
+// Where each MT/SM field is stored in an entry. The registry type of the
+// field says how its value is decoded
+var entryFields = map[int]func(*StockEntry) any{
+	FieldStockIndex:          func(s *StockEntry) any { return &s.StockIndex },
+	FieldLastPrice:           func(s *StockEntry) any { return &s.LastPrice },
+	FieldPreclosePrice:       func(s *StockEntry) any { return &s.PreclosePrice },
+	FieldIsPurchase:          func(s *StockEntry) any { return &s.IsPurchase },
+	FieldTotalBoughtQuantity: func(s *StockEntry) any { return &s.TotalBoughtQuantity },
+	FieldAccumulatedValue:    func(s *StockEntry) any { return &s.AccumulatedValue },
+	FieldBuyValue:            func(s *StockEntry) any { return &s.BuyValue },
+	FieldBuyVolumeMorning:    func(s *StockEntry) any { return &s.BuyVolumeMorning },
+	FieldBuyVolumeAfternoon:  func(s *StockEntry) any { return &s.BuyVolumeAfternoon },
+	FieldSellVolumeMorning:   func(s *StockEntry) any { return &s.SellVolumeMorning },
+	FieldSellVolumeAfternoon: func(s *StockEntry) any { return &s.SellVolumeAfternoon },
+}
+
+// Applies a partial MT/SM frame on top of the entry. Only the fields present
+// in the frame are overwritten, so fields sent as 0 are kept as 0
+func (s *StockEntry) Merge(fields FieldValues) error {
+	for code, raw := range fields {
+		field, ok := entryFields[code]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		if err := decodeField(Fields[code].Type, raw, field(s)); err != nil {
+			return fmt.Errorf("field %d: %w", code, err)
+		}
+	}
+	return nil
+}
+
+func decodeField(t FieldType, raw json.RawMessage, dst any) error {
+	switch t {
+	case TypeInt:
+		n, err := strconv.ParseUint(string(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s is not an int", raw)
+		}
+		*dst.(*uint) = uint(n)
+	case TypeFloat:
+		f, err := strconv.ParseFloat(string(raw), 32)
+		if err != nil {
+			return fmt.Errorf("%s is not a float", raw)
+		}
+		*dst.(*float32) = float32(f)
+	default:
+		return fmt.Errorf("%s fields cannot be stored in an entry", t)
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package ws
 
 import (
+	"bursa-alert/internal"
 	"context"
 	"encoding/json"
 	"errors"
 	"log"
+	"slices"
+	"sync"
 	"time"
 
 	"nhooyr.io/websocket"
@@ -12,12 +15,18 @@ import (
 
 type MessageHandler func(*Connection, []byte) error
 
+// Sees every inbound message, whether or not it has a handler. Frames that are
+// not text are reported with their frame type in angle brackets, e.g. <MessageBinary>
+type Observer func(mt string, data []byte)
+
 func newConnection(ctx context.Context, conn *websocket.Conn) Connection {
 	ctx, cancel := context.WithCancel(ctx)
 	return Connection{
 		conn:     conn,
 		ctx:      ctx,
 		handlers: make(map[string]MessageHandler),
+		subs:     &subscriptions{},
+		cancel:   cancel,
 		timeout: time.AfterFunc(30*time.Second, func() {
 			cancel()
 		}),
@@ -25,10 +34,14 @@ func newConnection(ctx context.Context, conn *websocket.Conn) Connection {
 }
 
 type Connection struct {
-	conn     *websocket.Conn
-	handlers map[string]MessageHandler
-	ctx      context.Context
-	timeout  *time.Timer
+	conn      *websocket.Conn
+	handlers  map[string]MessageHandler
+	ctx       context.Context
+	cancel    context.CancelFunc
+	timeout   *time.Timer
+	recorder  *Recorder
+	observers []Observer
+	subs      *subscriptions
 }
 
 func (c *Connection) StartReadLoop() error {
@@ -37,12 +50,15 @@ func (c *Connection) StartReadLoop() error {
 		case <-c.ctx.Done():
 			return nil
 		default:
-			mt, data, err := c.conn.Read(c.ctx)
+			mt, data, err := c.read(c.ctx)
 			if err != nil {
 				return err
 			}
 			if mt != websocket.MessageText {
 				log.Println("Received message of type ", mt.String())
+				for _, o := range c.observers {
+					o("<"+mt.String()+">", data)
+				}
 				continue
 			}
 			if err := c.HandleMessage(data); err != nil {
@@ -52,11 +68,32 @@ func (c *Connection) StartReadLoop() error {
 	}
 }
 
+// Closes the socket and stops the read timeout. The read loop, if running,
+// returns
+func (c *Connection) Close() error {
+	c.timeout.Stop()
+	c.cancel()
+	return c.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (c *Connection) read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	mt, data, err := c.conn.Read(ctx)
+	if err == nil && mt == websocket.MessageText {
+		if err := c.recorder.Record(DirIn, data); err != nil {
+			log.Println("Failed to record frame: ", err)
+		}
+	}
+	return mt, data, err
+}
+
 func (c *Connection) WriteJson(m map[string]any) error {
 	data, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
+	if err := c.recorder.Record(DirOut, data); err != nil {
+		log.Println("Failed to record frame: ", err)
+	}
 	return c.conn.Write(c.ctx, websocket.MessageText, data)
 }
 
@@ -79,6 +116,9 @@ func (c *Connection) HandleMessage(m []byte) error {
 			return errors.New("no data found")
 		}
 		mb, _ := json.Marshal(message["data"])
+		for _, o := range c.observers {
+			o(mt, mb)
+		}
 		if f, ok := c.handlers[mt]; ok {
 			if err := f(c, mb); err != nil {
 				return err
@@ -88,19 +128,60 @@ func (c *Connection) HandleMessage(m []byte) error {
 	return nil
 }
 
+// Replaces the connection's subscriptions with ids. The protocol has no
+// unsubscribe message, so every change re-issues RS with the full set
 func (c *Connection) Subscribe(ids []uint) error {
-	return c.WriteJson(map[string]any{
-		"data": map[string]any{
-			"1":   ids,
-			"20":  255,
-			"21":  []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
-			"52":  0,
-			"60":  []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
-			"66":  1,
-			"67":  1,
-			"69":  []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
-			"229": len(ids),
-		},
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.subscribe(ids)
+}
+
+func (c *Connection) subscribe(ids []uint) error {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if err := c.WriteJson(map[string]any{
+		"data": internal.Encode(map[int]any{
+			internal.FieldStockIndex:        ids,
+			internal.FieldRS20:              255,
+			internal.FieldRS21:              []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
+			internal.FieldRS52:              0,
+			internal.FieldRS60:              []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
+			internal.FieldFlag66:            1,
+			internal.FieldRS67:              1,
+			internal.FieldRS69:              []int{0, 0, 0, 0, 0, 0, 0, 0, 0},
+			internal.FieldSubscriptionCount: len(ids),
+		}),
 		"mt": "RS",
-	})
+	}); err != nil {
+		return err
+	}
+	c.subs.ids = ids
+	return nil
+}
+
+func (c *Connection) AddSubscriptions(ids ...uint) error {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.subscribe(append(slices.Clone(c.subs.ids), ids...))
+}
+
+func (c *Connection) RemoveSubscriptions(ids ...uint) error {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.subscribe(slices.DeleteFunc(slices.Clone(c.subs.ids), func(id uint) bool {
+		return slices.Contains(ids, id)
+	}))
+}
+
+// Stock ids of the latest RS, sorted
+func (c *Connection) Subscriptions() []uint {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return slices.Clone(c.subs.ids)
+}
+
+type subscriptions struct {
+	mu  sync.Mutex
+	ids []uint
 }
@@ -1,6 +1,9 @@
 package ws
 
-import "time"
+import (
+	"bursa-alert/internal"
+	"time"
+)
 
 func pingHandler(c *Connection, _ []byte) error {
 	if c.timeout != nil {
@@ -21,14 +24,14 @@ func v3RdHandler(c *Connection, _ []byte) error {
 		return nil
 	}
 	return c.WriteJson(map[string]any{
-		"data": map[string]uint{
-			"44":  0,
-			"45":  1,
-			"46":  13107265,
-			"47":  0,
-			"66":  1,
-			"316": 0,
-		},
+		"data": internal.Encode(map[int]any{
+			internal.FieldSS44:   0,
+			internal.FieldSS45:   1,
+			internal.FieldSS46:   13107265,
+			internal.FieldSS47:   0,
+			internal.FieldFlag66: 1,
+			internal.FieldSS316:  0,
+		}),
 		"mt": "SS",
 	})
 }
@@ -43,23 +46,23 @@ func v1RdHandler(c *Connection, _ []byte) error {
 	}
 
 	return c.WriteJson(map[string]any{
-		"data": map[string]any{
-			"44":  0,
-			"45":  1,
-			"46":  65,
-			"47":  0,
-			"66":  1,
-			"316": 0,
-		},
+		"data": internal.Encode(map[int]any{
+			internal.FieldSS44:   0,
+			internal.FieldSS45:   1,
+			internal.FieldSS46:   65,
+			internal.FieldSS47:   0,
+			internal.FieldFlag66: 1,
+			internal.FieldSS316:  0,
+		}),
 		"mt": "SS",
 	})
 }
 
 func rfData(n uint8) map[string]any {
 	return map[string]any{
-		"data": map[string]uint8{
-			"42": n,
-		},
+		"data": internal.Encode(map[int]any{
+			internal.FieldRF42: n,
+		}),
 		"mt": "RF",
 	}
 }
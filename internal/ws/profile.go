@@ -0,0 +1,192 @@
+package ws
+
+import (
+	"bursa-alert/internal"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the profile file format understood by LoadProfiles
+const ProfileVersion = 1
+
+// Everything NewConnection needs to know about the upstream handshake.
+// These change whenever Maybank updates the dashboard
+type Profile struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Names as in crypto/tls, e.g. TLS_RSA_WITH_AES_256_GCM_SHA384
+	CipherSuites []string `yaml:"cipher_suites"`
+	// LG payload. Field 16 is a random session number generated on connect
+	Login map[string]any `yaml:"login"`
+	// RD reply variant, v1 or v3
+	RdHandler string `yaml:"rd_handler"`
+}
+
+type ProfileFile struct {
+	Version int `yaml:"version"`
+	// Profile used when none is requested by name
+	Default  string    `yaml:"default"`
+	Profiles []Profile `yaml:"profiles"`
+}
+
+var DefaultProfile = Profile{
+	Name: "default",
+	URL:  "wss://mbbpfs2600.cyberstock.com.my/",
+	Headers: map[string]string{
+		"Accept":                   "*/*",
+		"Accept-Encoding":          "gzip, deflate, br",
+		"Accept-Language":          "en-US,en;q=0.5",
+		"Cache-Control":            "no-cache",
+		"Connection":               "keep-alive, Upgrade",
+		"DNT":                      "1",
+		"Host":                     "mbbpfs1600.cyberstock.com.my",
+		"Origin":                   "https://ost.maybank2u.com.my",
+		"Pragma":                   "no-cache",
+		"Sec-Fetch-Dest":           "websocket",
+		"Sec-Fetch-Mode":           "websocket",
+		"Sec-Fetch-Site":           "cross-site",
+		"Sec-WebSocket-Extensions": "permessage-deflate",
+		"Upgrade":                  "websocket",
+		"User-Agent":               "Mozilla/5.0 (Windows NT 10.0; rv:114.0) Gecko/20100101 Firefox/114.0",
+	},
+	CipherSuites: []string{"TLS_RSA_WITH_AES_256_GCM_SHA384"},
+	Login: map[string]any{
+		"14":  "this is hash value",
+		"37":  4325,
+		"64":  196608,
+		"65":  2022,
+		"271": "3b456bb11a511fcd3fa0b6ccf05faf3f5a9809cffd6d5230b09b13aa1c171db3",
+	},
+	RdHandler: "v3",
+}
+
+// LG fields every profile must provide
+var requiredLoginFields = []int{
+	internal.FieldLoginHash,
+	internal.FieldLogin37,
+	internal.FieldLoginVersion,
+	internal.FieldLoginYear,
+	internal.FieldLoginToken,
+}
+
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return errors.New("profile has no name")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("profile %s: %s is not a websocket url", p.Name, p.URL)
+	}
+	if _, err := p.cipherSuites(); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	for _, field := range requiredLoginFields {
+		if _, ok := p.Login[internal.Key(field)]; !ok {
+			return fmt.Errorf("profile %s: login field %d is missing", p.Name, field)
+		}
+	}
+	if _, err := p.rdHandler(); err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+func (p Profile) header() http.Header {
+	h := make(http.Header, len(p.Headers))
+	for k, v := range p.Headers {
+		h.Set(k, v)
+	}
+	return h
+}
+
+func (p Profile) cipherSuites() ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, len(p.CipherSuites))
+	for i, name := range p.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a known cipher suite", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (p Profile) rdHandler() (MessageHandler, error) {
+	switch p.RdHandler {
+	case "v1":
+		return v1RdHandler, nil
+	case "v3":
+		return v3RdHandler, nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid rd handler", p.RdHandler)
+	}
+}
+
+// Reads and validates a profile file
+func LoadProfiles(path string) (ProfileFile, error) {
+	var f ProfileFile
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return f, err
+	}
+	return f, f.Validate()
+}
+
+func (f ProfileFile) Validate() error {
+	if f.Version != ProfileVersion {
+		return fmt.Errorf("profile file version %d is not supported, expected %d", f.Version, ProfileVersion)
+	}
+	names := make(map[string]bool)
+	for _, p := range f.Profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return fmt.Errorf("profile %s is defined twice", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if f.Default != "" && !names[f.Default] {
+		return fmt.Errorf("default profile %s does not exist", f.Default)
+	}
+	return nil
+}
+
+// Looks up a profile by name. An empty name selects the file's default,
+// falling back to DefaultProfile
+func (f ProfileFile) Get(name string) (Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" || name == DefaultProfile.Name {
+		for _, p := range f.Profiles {
+			if p.Name == DefaultProfile.Name {
+				return p, nil
+			}
+		}
+		return DefaultProfile, nil
+	}
+	for _, p := range f.Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("profile %s does not exist", name)
+}
@@ -0,0 +1,73 @@
+package ws_test
+
+import (
+	"bursa-alert/internal/ws"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const PROFILES = `
+version: 1
+default: legacy
+profiles:
+  - name: legacy
+    url: wss://mbbpfs1600.cyberstock.com.my/
+    cipher_suites: [TLS_RSA_WITH_AES_256_GCM_SHA384]
+    login: {14: hash, 37: 1, 64: 2, 65: 2021, 271: abc}
+    rd_handler: v1
+  - name: current
+    url: wss://mbbpfs2600.cyberstock.com.my/
+    login: {14: hash, 37: 1, 64: 2, 65: 2022, 271: abc}
+    rd_handler: v3
+`
+
+func TestLoadProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	if err := os.WriteFile(path, []byte(PROFILES), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ws.LoadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := f.Get("")
+	if err != nil || p.Name != "legacy" || p.Login["65"] != 2021 {
+		t.Errorf("default profile not selected: %+v %v", p, err)
+	}
+	if p, err := f.Get("current"); err != nil || p.RdHandler != "v3" {
+		t.Errorf("named profile not selected: %+v %v", p, err)
+	}
+	if _, err := f.Get("missing"); err == nil {
+		t.Error("missing profile was found")
+	}
+	if p, err := (ws.ProfileFile{Version: ws.ProfileVersion}).Get(""); err != nil || p.Name != ws.DefaultProfile.Name {
+		t.Errorf("empty file does not fall back to the built in profile")
+	}
+}
+
+func TestInvalidProfiles(t *testing.T) {
+	if err := ws.DefaultProfile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, replace := range [][2]string{
+		{"version: 1", "version: 2"},
+		{"default: legacy", "default: other"},
+		{"name: current", "name: legacy"},
+		{"wss://mbbpfs1600", "https://mbbpfs1600"},
+		{"TLS_RSA_WITH_AES_256_GCM_SHA384", "TLS_MADE_UP"},
+		{"rd_handler: v1", "rd_handler: v2"},
+		{"271: abc}\n    rd_handler: v1", "}\n    rd_handler: v1"},
+	} {
+		var f ws.ProfileFile
+		if err := yaml.Unmarshal([]byte(strings.Replace(PROFILES, replace[0], replace[1], 1)), &f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Validate(); err == nil {
+			t.Errorf("profile file with %q is valid", replace[1])
+		}
+	}
+}
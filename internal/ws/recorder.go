@@ -0,0 +1,216 @@
+package ws
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	DirIn  Direction = "in"
+	DirOut Direction = "out"
+)
+
+// A single recorded message. Inbound frames holding several messages are
+// written as one line per message
+type Frame struct {
+	// Nanoseconds since the recorder started, from the monotonic clock
+	T int64 `json:"t"`
+	// Wall clock in unix milliseconds
+	At   int64           `json:"at"`
+	Dir  Direction       `json:"dir"`
+	Mt   string          `json:"mt"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Frames that could not be parsed are kept verbatim
+	Raw string `json:"raw,omitempty"`
+}
+
+// Writes frames as gzip compressed JSON lines. Safe for use by several connections
+type Recorder struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	start   time.Time
+	closer  io.Closer
+	gz      *gzip.Writer
+	flushed time.Time
+	closed  bool
+
+	// Rotation, only set for recorders created with NewRotatingRecorder
+	dir      string
+	loc      *time.Location
+	maxBytes int64
+	day      string
+	part     int
+	written  int64
+}
+
+// Records to a single writer. Closing the recorder closes w if it is an io.Closer
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{now: time.Now, start: time.Now(), gz: gzip.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	return r
+}
+
+// Records to dir/<trading day>/<part>.jsonl.gz, starting a new file when the
+// day changes in loc or when maxBytes of uncompressed data have been written
+func NewRotatingRecorder(dir string, maxBytes int64, loc *time.Location) (*Recorder, error) {
+	r := &Recorder{now: time.Now, start: time.Now(), dir: dir, maxBytes: maxBytes, loc: loc}
+	if err := r.rotate(r.start); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Replaces the clock used for frame times and day rotation
+func (r *Recorder) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	day := now.In(r.loc).Format(time.DateOnly)
+	if day != r.day {
+		r.day = day
+		r.part = 0
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, day), 0755); err != nil {
+		return err
+	}
+	for {
+		r.part++
+		path := filepath.Join(r.dir, day, fmt.Sprintf("%03d.jsonl.gz", r.part))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.closer, r.gz = f, gzip.NewWriter(f)
+		r.written = 0
+		return nil
+	}
+}
+
+func (r *Recorder) closeFile() error {
+	if r.gz == nil {
+		return nil
+	}
+	if err := r.gz.Close(); err != nil {
+		return err
+	}
+	r.gz = nil
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// Splits a raw websocket frame into messages and records them
+func (r *Recorder) Record(dir Direction, frame []byte) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	now := r.now()
+	r.mu.Unlock()
+	base := Frame{T: int64(now.Sub(r.start)), At: now.UnixMilli(), Dir: dir}
+	var messages []json.RawMessage
+	if dir == DirOut {
+		messages = []json.RawMessage{frame}
+	} else if err := json.Unmarshal(frame, &messages); err != nil {
+		base.Raw = string(frame)
+		return r.write(now, base)
+	}
+	for _, m := range messages {
+		var message struct {
+			Mt   string          `json:"mt"`
+			Data json.RawMessage `json:"data"`
+		}
+		f := base
+		if err := json.Unmarshal(m, &message); err != nil {
+			f.Raw = string(m)
+		} else {
+			f.Mt, f.Data = message.Mt, message.Data
+		}
+		if err := r.write(now, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) write(now time.Time, f Frame) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	if r.dir != "" {
+		day := now.In(r.loc).Format(time.DateOnly)
+		if day != r.day || (r.maxBytes > 0 && r.written+int64(len(b)) > r.maxBytes) {
+			if err := r.rotate(now); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := r.gz.Write(b); err != nil {
+		return err
+	}
+	r.written += int64(len(b))
+	// Keep the file readable if the process dies
+	if now.Sub(r.flushed) > time.Second {
+		r.flushed = now
+		return r.gz.Flush()
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.closeFile()
+}
+
+// Reads a recording written by a Recorder, calling fn for each frame in order
+func ReadFrames(r io.Reader, fn func(Frame) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		var f Frame
+		if err := dec.Decode(&f); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			// Recordings cut short by a crash end mid line
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ws_test
+
+import (
+	"bursa-alert/internal/ws"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, b []byte) []ws.Frame {
+	t.Helper()
+	var frames []ws.Frame
+	if err := ws.ReadFrames(bytes.NewReader(b), func(f ws.Frame) error {
+		frames = append(frames, f)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	r := ws.NewRecorder(&buf)
+	for _, f := range []struct {
+		dir   ws.Direction
+		frame string
+	}{
+		{ws.DirIn, `[{"mt":"MT","data":{"1":1}},{"mt":"SM","data":{"1":2}}]`},
+		{ws.DirOut, `{"mt":"RS","data":{"1":[1]}}`},
+		{ws.DirIn, `not json`},
+	} {
+		if err := r.Record(f.dir, []byte(f.frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frames := readAll(t, buf.Bytes())
+	if len(frames) != 4 {
+		t.Fatalf("expected 4 frames, got %+v", frames)
+	}
+	// Inbound frames are split into one line per message
+	for i, want := range []struct {
+		dir      ws.Direction
+		mt, data string
+	}{
+		{ws.DirIn, "MT", `{"1":1}`},
+		{ws.DirIn, "SM", `{"1":2}`},
+		{ws.DirOut, "RS", `{"1":[1]}`},
+	} {
+		f := frames[i]
+		if f.Dir != want.dir || f.Mt != want.mt || string(f.Data) != want.data {
+			t.Errorf("frame %d is %+v", i, f)
+		}
+	}
+	if frames[3].Raw != "not json" {
+		t.Errorf("unparsed frame recorded as %+v", frames[3])
+	}
+
+	// A recording cut short keeps the frames before the cut
+	full := buf.Bytes()
+	if frames := readAll(t, full[:len(full)-10]); len(frames) == 0 || frames[0].Mt != "MT" {
+		t.Errorf("truncated recording read as %+v", frames)
+	}
+}
+
+func TestRotatingRecorder(t *testing.T) {
+	dir := t.TempDir()
+	loc := time.FixedZone("MYT", 8*60*60)
+	r, err := ws.NewRotatingRecorder(dir, 200, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 3, 23, 59, 0, 0, loc)
+	r.SetClock(func() time.Time { return now })
+	frame := []byte(`[{"mt":"MT","data":{"1":1,"209":1000}}]`)
+	// Each line is about 100 bytes, so two fit in a part
+	for i := 0; i < 3; i++ {
+		if err := r.Record(ws.DirIn, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Midnight in loc starts a new day
+	now = now.Add(2 * time.Minute)
+	if err := r.Record(ws.DirIn, frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, n := range map[string]int{
+		"2024-06-03/001.jsonl.gz": 2,
+		"2024-06-03/002.jsonl.gz": 1,
+		"2024-06-04/001.jsonl.gz": 1,
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if frames := readAll(t, b); len(frames) != n {
+			t.Errorf("%s holds %d frames, expected %d", path, len(frames), n)
+		}
+	}
+}
@@ -1,50 +1,39 @@
 package ws
 
 import (
+	"bursa-alert/internal"
 	"bursa-alert/lib/utils"
 	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"nhooyr.io/websocket"
 )
 
-var headers http.Header = map[string][]string{
-	"Accept":                   {"*/*"},
-	"Accept-Encoding":          {"gzip, deflate, br"},
-	"Accept-Language":          {"en-US,en;q=0.5"},
-	"Cache-Control":            {"no-cache"},
-	"Connection":               {"keep-alive, Upgrade"},
-	"DNT":                      {"1"},
-	"Host":                     {"mbbpfs1600.cyberstock.com.my"},
-	"Origin":                   {"https://ost.maybank2u.com.my"},
-	"Pragma":                   {"no-cache"},
-	"Sec-Fetch-Dest":           {"websocket"},
-	"Sec-Fetch-Mode":           {"websocket"},
-	"Sec-Fetch-Site":           {"cross-site"},
-	"Sec-WebSocket-Extensions": {"permessage-deflate"},
-	// "Sec-WebSocket-Key":        {"LdSCw1q/Ggfve5nnbcOqRg=="},
-	// "Sec-WebSocket-Version":    {"13"},
-	"Upgrade":    {"websocket"},
-	"User-Agent": {"Mozilla/5.0 (Windows NT 10.0; rv:114.0) Gecko/20100101 Firefox/114.0"},
-}
-
 type connectionOptions struct {
-	// v1           bool
-	initHandlers map[string]MessageHandler
+	profile          Profile
+	handshakeTimeout time.Duration
+	initHandlers     map[string]MessageHandler
+	recorder         *Recorder
+	observers        []Observer
+	stageHook        func(Stage)
 }
 
 func defaultOptions() connectionOptions {
-	return connectionOptions{initHandlers: make(map[string]MessageHandler)}
+	return connectionOptions{profile: DefaultProfile, handshakeTimeout: DefaultHandshakeTimeout, initHandlers: make(map[string]MessageHandler)}
 }
 
 type OptionModifier func(*connectionOptions)
 
-//
-// var WithUseV3 OptionModifier = func(co *connectionOptions) {
-// 	co.v1 = true
-// }
+// Connects using p instead of DefaultProfile. p should have been validated
+func WithProfile(p Profile) OptionModifier {
+	return func(co *connectionOptions) {
+		co.profile = p
+	}
+}
 
 func WithMessageHandler(mt string, mh MessageHandler) OptionModifier {
 	return func(co *connectionOptions) {
@@ -52,49 +41,114 @@ func WithMessageHandler(mt string, mh MessageHandler) OptionModifier {
 	}
 }
 
+// Dials url instead of the profile's url
+func WithURL(url string) OptionModifier {
+	return func(co *connectionOptions) {
+		co.profile.URL = url
+	}
+}
+
+// Records every inbound and outbound frame of the connection
+func WithRecorder(r *Recorder) OptionModifier {
+	return func(co *connectionOptions) {
+		co.recorder = r
+	}
+}
+
+// Adds an observer. Every observer sees every message
+func WithObserver(o Observer) OptionModifier {
+	return func(co *connectionOptions) {
+		co.observers = append(co.observers, o)
+	}
+}
+
+// Handshake progress reported through WithStageHook
+type Stage string
+
+const (
+	StageDialing    Stage = "dialing"
+	StageLoggingIn  Stage = "logging_in"
+	StageSubscribed Stage = "subscribed"
+)
+
+// Time from dialing until the subscription is accepted before the attempt
+// fails
+const DefaultHandshakeTimeout = 30 * time.Second
+
+func WithHandshakeTimeout(d time.Duration) OptionModifier {
+	return func(co *connectionOptions) {
+		co.handshakeTimeout = d
+	}
+}
+
+func WithStageHook(f func(Stage)) OptionModifier {
+	return func(co *connectionOptions) {
+		co.stageHook = f
+	}
+}
+
 func NewConnection(ctx context.Context, subscriptions []uint, options ...OptionModifier) (Connection, error) {
 	opts := defaultOptions()
 	for _, opt := range options {
 		opt(&opts)
 	}
+	stage := func(s Stage) {
+		if opts.stageHook != nil {
+			opts.stageHook(s)
+		}
+	}
+
+	profile := opts.profile
+	if err := profile.Validate(); err != nil {
+		return Connection{}, err
+	}
+	cipherSuites, _ := profile.cipherSuites()
+	rdHandler, _ := profile.rdHandler()
 
 	client := http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
-				CipherSuites: []uint16{
-					tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				},
+				CipherSuites: cipherSuites,
 			},
 		},
 	}
 
-	c, _, err := websocket.Dial(ctx, "wss://mbbpfs2600.cyberstock.com.my/", &websocket.DialOptions{
+	// Servers that accept the socket but never answer fail the attempt
+	// instead of holding it open
+	handshake, cancel := context.WithTimeout(ctx, opts.handshakeTimeout)
+	defer cancel()
+	stage(StageDialing)
+	c, _, err := websocket.Dial(handshake, profile.URL, &websocket.DialOptions{
 		HTTPClient: &client,
-		HTTPHeader: headers,
+		HTTPHeader: profile.header(),
 	})
 	if err != nil {
 		return Connection{}, err
 	}
 	conn := newConnection(ctx, c)
+	// Failed handshakes do not leave the socket open
+	ok := false
+	defer func() {
+		if !ok {
+			conn.Close()
+		}
+	}()
+	conn.recorder = opts.recorder
+	conn.observers = opts.observers
+	login := make(map[string]any, len(profile.Login)+1)
+	for k, v := range profile.Login {
+		login[k] = v
+	}
+	login[internal.Key(internal.FieldSessionNumber)] = utils.RandInt(12)
 	if err := conn.WriteJson(map[string]any{
-		"data": map[string]any{
-			"14":  "this is hash value",
-			"37":  4325,
-			"64":  196608,
-			"65":  2022,
-			"271": "3b456bb11a511fcd3fa0b6ccf05faf3f5a9809cffd6d5230b09b13aa1c171db3",
-			"16":  utils.RandInt(12),
-		},
-		"mt": "LG",
+		"data": login,
+		"mt":   "LG",
 	}); err != nil {
 		return Connection{}, err
 	}
+	stage(StageLoggingIn)
 	conn.AddHandler("MS", pingHandler)
-	// if opts.v1 {
-	conn.AddHandler("RD", v3RdHandler)
-	// } else {
-	// 	conn.AddHandler("RD", v1RdHandler)
-	// }
+	conn.AddHandler("RD", rdHandler)
 	for mt, handler := range opts.initHandlers {
 		conn.AddHandler(mt, handler)
 	}
@@ -106,8 +160,11 @@ func NewConnection(ctx context.Context, subscriptions []uint, options ...OptionM
 	})
 	// Wait for mt:SU (ready for subscribe)
 	for {
-		mt, data, err := conn.conn.Read(ctx)
+		mt, data, err := conn.read(handshake)
 		if err != nil {
+			if handshake.Err() != nil && ctx.Err() == nil {
+				return Connection{}, fmt.Errorf("handshake timed out after %s: %w", opts.handshakeTimeout, err)
+			}
 			return Connection{}, err
 		}
 		if mt != websocket.MessageText {
@@ -118,10 +175,12 @@ func NewConnection(ctx context.Context, subscriptions []uint, options ...OptionM
 		}
 		if subscribed {
 			log.Println("Subscription complete")
+			stage(StageSubscribed)
 			break
 		}
 
 	}
 
+	ok = true
 	return conn, nil
 }
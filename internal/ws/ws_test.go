@@ -0,0 +1,189 @@
+package ws_test
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/internal/ws/wstest"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := ws.NewConnection(ctx, []uint{1, 2, 3}, ws.WithURL(server.URL())); err != nil {
+		t.Fatal(err)
+	}
+	session := server.Session(time.Second)
+	if _, ok := session.WaitFor("RS", time.Second); !ok {
+		t.Fatal("no subscription received")
+	}
+	if ids := session.Subscribed(); !slices.Equal(ids, []uint{1, 2, 3}) {
+		t.Errorf("subscribed to %v", ids)
+	}
+	var mts []string
+	for _, m := range session.Received() {
+		mts = append(mts, m.Mt)
+	}
+	if !slices.Equal(mts, []string{"LG", "RF", "RF", "RF", "SS", "RS"}) {
+		t.Errorf("unexpected handshake %v", mts)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := wstest.NewServer()
+	server.PingInterval = 10 * time.Millisecond
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := ws.NewConnection(ctx, []uint{1}, ws.WithURL(server.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.StartReadLoop()
+	if _, ok := server.Session(time.Second).WaitFor("AC", time.Second); !ok {
+		t.Error("ping was not acknowledged")
+	}
+}
+
+func TestData(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan json.RawMessage, 1)
+	conn, err := ws.NewConnection(ctx, []uint{1}, ws.WithURL(server.URL()), ws.WithMessageHandler("MT", func(_ *ws.Connection, b []byte) error {
+		received <- b
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- conn.StartReadLoop() }()
+
+	session := server.Session(time.Second)
+	if err := session.Send("MT", map[string]any{"1": 1, "209": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-received:
+		if string(b) != `{"1":1,"209":1000}` {
+			t.Errorf("unexpected data %s", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no data received")
+	}
+
+	// Malformed frames end the read loop
+	if err := session.SendRaw([]byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("malformed frame was accepted")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read loop did not stop")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := ws.NewConnection(ctx, []uint{1}, ws.WithURL(server.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- conn.StartReadLoop() }()
+	server.Session(time.Second).Close()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("disconnect was not reported")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read loop did not stop")
+	}
+}
+
+func TestSlowHandshake(t *testing.T) {
+	server := wstest.NewServer()
+	server.Delay = 200 * time.Millisecond
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := ws.NewConnection(ctx, []uint{1}, ws.WithURL(server.URL())); err == nil {
+		t.Error("handshake did not time out")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	server := wstest.NewServer()
+	server.Delay = time.Second
+	defer server.Close()
+
+	// The caller's context has no deadline
+	start := time.Now()
+	if _, err := ws.NewConnection(context.Background(), []uint{1}, ws.WithURL(server.URL()), ws.WithHandshakeTimeout(100*time.Millisecond)); err == nil {
+		t.Error("handshake did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("handshake gave up after %s", elapsed)
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := ws.NewConnection(ctx, []uint{1, 2}, ws.WithURL(server.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := server.Session(time.Second)
+	if _, ok := session.WaitFor("RS", time.Second); !ok {
+		t.Fatal("no subscription received")
+	}
+	if err := conn.AddSubscriptions(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.RemoveSubscriptions(2); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint{1, 3}
+	if ids := conn.Subscriptions(); !slices.Equal(ids, want) {
+		t.Errorf("connection tracks %v", ids)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !slices.Equal(session.Subscribed(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server subscribed to %v", session.Subscribed())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rs := 0
+	for _, m := range session.Received() {
+		if m.Mt == "RS" {
+			rs++
+		}
+	}
+	if rs != 3 {
+		t.Errorf("expected 3 RS messages, got %d", rs)
+	}
+}
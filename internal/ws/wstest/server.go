@@ -0,0 +1,251 @@
+// Fake cyberstock server for tests and offline development
+package wstest
+
+import (
+	"bursa-alert/internal"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+type Stock struct {
+	Ticker string
+	Name   string
+}
+
+type Message struct {
+	Mt   string          `json:"mt"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Replies to a message sent by the client
+type Handler func(*Session, json.RawMessage) error
+
+// Speaks enough of the protocol for ws.NewConnection: LG is answered with RD,
+// SS with the SL metadata pages followed by SU, and RS subscriptions are recorded.
+// Every default can be replaced with Handle
+type Server struct {
+	*httptest.Server
+	// Listed in SL pages, indexed by position
+	Stocks   []Stock
+	PageSize int
+	// Sent as MS when positive
+	PingInterval time.Duration
+	// Applied before every reply from the default handlers
+	Delay time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	sessions chan *Session
+}
+
+func NewServer() *Server {
+	s := &Server{
+		PageSize: 100,
+		handlers: make(map[string]Handler),
+		sessions: make(chan *Session, 16),
+	}
+	s.handlers["LG"] = func(ss *Session, _ json.RawMessage) error {
+		return ss.Reply("RD", map[string]any{})
+	}
+	s.handlers["SS"] = func(ss *Session, _ json.RawMessage) error {
+		if err := ss.SendMetadata(); err != nil {
+			return err
+		}
+		return ss.Reply("SU", map[string]any{})
+	}
+	s.handlers["RS"] = func(ss *Session, b json.RawMessage) error {
+		fields, err := internal.DecodeFields("RS", b)
+		if err != nil {
+			return err
+		}
+		var ids []uint
+		if err := fields.Get(internal.FieldStockIndex, &ids); err != nil {
+			return err
+		}
+		ss.mu.Lock()
+		ss.subscribed = ids
+		ss.mu.Unlock()
+		return nil
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Dial URL for ws.WithURL
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.Server.URL, "http")
+}
+
+func (s *Server) Handle(mt string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[mt] = h
+}
+
+// Waits for the next client to connect
+func (s *Server) Session(timeout time.Duration) *Session {
+	select {
+	case ss := <-s.sessions:
+		return ss
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// The client sends the Maybank origin
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	ss := &Session{server: s, conn: conn, ctx: ctx, cancel: cancel}
+	select {
+	case s.sessions <- ss:
+	default:
+	}
+	if s.PingInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(s.PingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := ss.Send("MS", map[string]any{}); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		ss.mu.Lock()
+		ss.received = append(ss.received, m)
+		ss.mu.Unlock()
+		s.mu.Lock()
+		h, ok := s.handlers[m.Mt]
+		s.mu.Unlock()
+		if ok {
+			if err := h(ss, m.Data); err != nil {
+				conn.Close(websocket.StatusInternalError, err.Error())
+				return
+			}
+		}
+	}
+}
+
+// A single client connection
+type Session struct {
+	server *Server
+	conn   *websocket.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	received   []Message
+	subscribed []uint
+}
+
+// Sends a single message frame
+func (ss *Session) Send(mt string, data any) error {
+	b, err := json.Marshal([]map[string]any{{"mt": mt, "data": data}})
+	if err != nil {
+		return err
+	}
+	return ss.SendRaw(b)
+}
+
+// Sends b as is, e.g. to inject malformed frames
+func (ss *Session) SendRaw(b []byte) error {
+	return ss.conn.Write(ss.ctx, websocket.MessageText, b)
+}
+
+// Like Send, after the server's configured delay
+func (ss *Session) Reply(mt string, data any) error {
+	if ss.server.Delay > 0 {
+		select {
+		case <-ss.ctx.Done():
+			return ss.ctx.Err()
+		case <-time.After(ss.server.Delay):
+		}
+	}
+	return ss.Send(mt, data)
+}
+
+// Sends the server's stocks as SL pages
+func (ss *Session) SendMetadata() error {
+	stocks := ss.server.Stocks
+	for offset := 0; offset < len(stocks); offset += ss.server.PageSize {
+		page := stocks[offset:min(offset+ss.server.PageSize, len(stocks))]
+		names := make([]string, len(page))
+		tickers := make([]string, len(page))
+		for i, stock := range page {
+			names[i], tickers[i] = stock.Name, stock.Ticker
+		}
+		if err := ss.Reply("SL", internal.Encode(map[int]any{
+			internal.FieldNames:   names,
+			internal.FieldTickers: tickers,
+			internal.FieldOffset:  offset,
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closed once either side ends the connection
+func (ss *Session) Done() <-chan struct{} {
+	return ss.ctx.Done()
+}
+
+// Drops the connection
+func (ss *Session) Close() {
+	ss.cancel()
+}
+
+// Messages sent by the client so far
+func (ss *Session) Received() []Message {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]Message(nil), ss.received...)
+}
+
+// Stock ids from the latest RS
+func (ss *Session) Subscribed() []uint {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]uint(nil), ss.subscribed...)
+}
+
+// Waits for the client to send a message of type mt
+func (ss *Session) WaitFor(mt string, timeout time.Duration) (Message, bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, m := range ss.Received() {
+			if m.Mt == mt {
+				return m, true
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return Message{}, false
+}
@@ -1,40 +1,137 @@
 package alerts
 
 import (
+	"bursa-alert/lib/candles"
 	"bursa-alert/lib/global"
+	"bursa-alert/lib/indicators"
 	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Alert struct {
-	Label string   `yaml:"label" json:"label"`
-	Rules []Rule   `yaml:"rules" json:"rules"`
-	Tags  []string `yaml:"tags" json:"tags"`
+	Label string `yaml:"label" json:"label"`
+	// Format the alert was stored in. Zero for alerts stored before it was
+	// recorded
+	Version uint `yaml:"version,omitempty" json:"version,omitempty"`
+	// Flat list of rules that must all hold. Moved into When on load
+	Rules []Rule `yaml:"rules,omitempty" json:"rules,omitempty"`
+	// Tree of rules
+	When *Group `yaml:"when,omitempty" json:"when,omitempty"`
+	// Condition that must hold as well as the rules
+	Expr *Expression `yaml:"expr,omitempty" json:"expr,omitempty"`
+	Tags []string    `yaml:"tags" json:"tags"`
+	// When a matching condition notifies
+	Trigger Trigger `yaml:"trigger,omitempty" json:"trigger,omitempty"`
+	// Stocks the alert applies to
+	Scope Scope `yaml:"scope,omitempty" json:"scope,omitempty"`
+	// Metadata changes that fire the alert regardless of its rules
+	Events []models.MetadataEventKind `yaml:"events,omitempty" json:"events,omitempty"`
+	// Market phases the rules are evaluated in. Empty means all of them
+	Phases []session.Phase `yaml:"phases,omitempty" json:"phases,omitempty"`
 }
 
 type Rule struct {
 	A   eval       `yaml:"a" json:"a"`
 	Cmp comparator `yaml:"cmp" json:"cmp"`
 	B   eval       `yaml:"b" json:"b"`
+	// Minutes the comparison must have held at every entry
+	For uint `yaml:"for,omitempty" json:"for,omitempty"`
+	// Entries the comparison must have held at in the last Within minutes.
+	// For crossings, the number of crossings
+	Times  uint `yaml:"times,omitempty" json:"times,omitempty"`
+	Within uint `yaml:"within,omitempty" json:"within,omitempty"`
 }
 type eval struct {
 	Type  valueType `yaml:"type" json:"type"`
-	Var   variable  `yaml:"variable" json:"variable"`
+	Var   variable  `yaml:"value" json:"value"`
 	Const float32   `yaml:"constant" json:"constant"`
 }
 
 type variable struct {
-	T        variableType  `yaml:"type" json:"type"`
+	T variableType `yaml:"type" json:"type"`
 	// Oldest entry within x minutes
 	D uint `yaml:"duration" json:"duration"`
+	// Price that ticks_to_target counts towards
+	Target models.Price `yaml:"target,omitempty" json:"target,omitempty"`
+	// Bar size of candle variables, and how many bars back to look
+	Resolution candles.Resolution `yaml:"resolution,omitempty" json:"resolution,omitempty"`
+	Offset     uint               `yaml:"offset,omitempty" json:"offset,omitempty"`
+	// Reads how much the variable moved over the duration instead
+	Change change `yaml:"change,omitempty" json:"change,omitempty"`
+}
+
+// A variable is written either as its bare name or as a mapping with its
+// parameters
+type variableSpec struct {
+	T          variableType       `yaml:"type" json:"type"`
+	D          uint               `yaml:"duration" json:"duration"`
+	Target     models.Price       `yaml:"target,omitempty" json:"target,omitempty"`
+	Resolution candles.Resolution `yaml:"resolution,omitempty" json:"resolution,omitempty"`
+	Offset     uint               `yaml:"offset,omitempty" json:"offset,omitempty"`
+	Change     change             `yaml:"change,omitempty" json:"change,omitempty"`
+}
+
+func (v variable) bare() bool {
+	return v == variable{T: v.T}
+}
+
+func (v variable) MarshalJSON() ([]byte, error) {
+	if v.bare() {
+		return json.Marshal(string(v.T))
+	}
+	return json.Marshal(variableSpec(v))
+}
+
+func (v *variable) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = variable{T: variableType(s)}
+		return nil
+	}
+	var spec variableSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return err
+	}
+	*v = variable(spec)
+	return nil
+}
+
+func (v variable) MarshalYAML() (any, error) {
+	if v.bare() {
+		return string(v.T), nil
+	}
+	return variableSpec(v), nil
+}
+
+func (v *variable) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*v = variable{T: variableType(n.Value)}
+		return nil
+	}
+	var spec variableSpec
+	if err := n.Decode(&spec); err != nil {
+		return err
+	}
+	*v = variable(spec)
+	return nil
 }
 
 type (
 	variableType string
 	comparator   string
 	valueType    string
+	change       string
 )
 
 const (
@@ -44,6 +141,15 @@ const (
 	CmpGte    comparator = ">="
 	CmpLt     comparator = "<"
 	CmpLte    comparator = "<="
+	// A was at or below B at the previous entry and is above it now
+	CmpCrossesAbove comparator = "crosses_above"
+	// A was at or above B at the previous entry and is below it now
+	CmpCrossesBelow comparator = "crosses_below"
+)
+
+const (
+	ChangeAbsolute change = "absolute"
+	ChangePercent  change = "percent"
 )
 
 const (
@@ -60,14 +166,56 @@ const (
 	VarBuyVolume           variableType = "buy_volume"
 	VarSellVolume          variableType = "sell_volume"
 	VarBuyRate             variableType = "buy_rate"
+	// Ticks from preclose to last price
+	VarPriceChangeTicks variableType = "price_change_ticks"
+	// Ticks from last price up to the variable's target, negative if below
+	VarTicksToTarget variableType = "ticks_to_target"
+	// Fields of the candle at the variable's resolution and offset
+	VarCandleOpen   variableType = "candle_open"
+	VarCandleHigh   variableType = "candle_high"
+	VarCandleLow    variableType = "candle_low"
+	VarCandleClose  variableType = "candle_close"
+	VarCandleVolume variableType = "candle_volume"
+	VarCandleValue  variableType = "candle_value"
 )
 
+// Variables read from a stock's entries
+var entryVariables = []variableType{VarLastPrice, VarPreclosePrice, VarPriceChange, VarTotalBoughtQuantity, VarTradeValue, VarBuyVolume, VarSellVolume, VarBuyRate, VarPriceChangeTicks}
+
+var candleVariables = []variableType{VarCandleOpen, VarCandleHigh, VarCandleLow, VarCandleClose, VarCandleVolume, VarCandleValue}
+
 func (a Alert) Validate() error {
+	for _, kind := range a.Events {
+		if !slices.Contains(models.MetadataEventKinds, kind) {
+			return fmt.Errorf("alert %s failed to parse: %s is not a valid event", a.Label, kind)
+		}
+	}
+	for _, phase := range a.Phases {
+		if !phase.Valid() {
+			return fmt.Errorf("alert %s failed to parse: %s is not a valid phase", a.Label, phase)
+		}
+	}
 	for _, rule := range a.Rules {
 		if err := rule.validate(); err != nil {
 			return fmt.Errorf("alert %s failed to parse: %w", a.Label, err)
 		}
 	}
+	if a.When != nil {
+		if err := a.When.validate(); err != nil {
+			return fmt.Errorf("alert %s failed to parse: %w", a.Label, err)
+		}
+	}
+	if a.Expr != nil {
+		if err := a.Expr.Validate(); err != nil {
+			return fmt.Errorf("alert %s failed to parse: %w", a.Label, err)
+		}
+	}
+	if err := a.Trigger.validate(); err != nil {
+		return fmt.Errorf("alert %s failed to parse: %w", a.Label, err)
+	}
+	if err := a.Scope.validate(); err != nil {
+		return fmt.Errorf("alert %s failed to parse: scope: %w", a.Label, err)
+	}
 	return nil
 }
 
@@ -87,56 +235,201 @@ func (e eval) validate() error {
 	if e.Type == EvalVariable {
 		switch e.Var.T {
 		default:
-			return fmt.Errorf("%s is not a valid variable", e.Var.T)
-		case VarLastPrice, VarPreclosePrice, VarPriceChange, VarTotalBoughtQuantity, VarTradeValue, VarBuyVolume, VarSellVolume, VarBuyRate:
+			// Indicators are written as calls with their parameters, like rsi(14, 5m)
+			if !strings.Contains(string(e.Var.T), "(") {
+				return fmt.Errorf("%s is not a valid variable", e.Var.T)
+			}
+			if _, err := indicators.Parse(string(e.Var.T)); err != nil {
+				return err
+			}
+		case VarLastPrice, VarPreclosePrice, VarPriceChange, VarTotalBoughtQuantity, VarTradeValue, VarBuyVolume, VarSellVolume, VarBuyRate, VarPriceChangeTicks:
+		case VarTicksToTarget:
+			if e.Var.Target <= 0 {
+				return fmt.Errorf("%s needs a target price", e.Var.T)
+			}
+		case VarCandleOpen, VarCandleHigh, VarCandleLow, VarCandleClose, VarCandleVolume, VarCandleValue:
+			if err := e.Var.Resolution.Validate(); err != nil {
+				return fmt.Errorf("%s: %w", e.Var.T, err)
+			}
+		}
+		switch e.Var.Change {
+		default:
+			return fmt.Errorf("%s is not a valid change", e.Var.Change)
+		case "":
+		case ChangeAbsolute, ChangePercent:
+			if !e.Var.historic() {
+				return fmt.Errorf("%s has no history to change over", e.Var.T)
+			}
+			if e.Var.D == 0 {
+				return fmt.Errorf("change of %s needs a duration", e.Var.T)
+			}
 		}
 	} else {
-		if _, err := strconv.ParseFloat(string(e.Var.T), 32); err != nil {
+		if _, err := strconv.ParseFloat(string(e.Var.T), 64); err != nil {
 			return fmt.Errorf("%s is not a float", e.Var.T)
 		}
 	}
 	return nil
 }
 
-func (e *eval) float32(id uint) float32 {
+// A rule operand. Prices, and constants that are valid prices, compare
+// exactly. Anything else compares as a float
+type value struct {
+	price models.Price
+	exact bool
+	f     float64
+}
+
+func priceValue(p models.Price) value {
+	return value{price: p, exact: true, f: p.Float64()}
+}
+
+func floatValue(f float64) value {
+	return value{f: f}
+}
+
+// Negative, zero or positive as a is less than, equal to or greater than b.
+// False if either side is NaN
+func (a value) compare(b value) (int, bool) {
+	if a.exact && b.exact {
+		return cmp.Compare(a.price, b.price), true
+	}
+	if math.IsNaN(a.f) || math.IsNaN(b.f) {
+		return 0, false
+	}
+	return cmp.Compare(a.f, b.f), true
+}
+
+// Whether the operand can be read as of any entry in the history
+func (e eval) historic() bool {
+	return e.Type == EvalConstant || e.Var.historic()
+}
+
+func (e *eval) valueAt(id uint, c *cursor) value {
 	if e.Type == EvalConstant {
-		f, err := strconv.ParseFloat(string(e.Var.T), 32)
+		if p, err := models.ParsePrice(string(e.Var.T)); err == nil {
+			return priceValue(p)
+		}
+		f, err := strconv.ParseFloat(string(e.Var.T), 64)
 		if err != nil {
 			panic(err)
 		}
-		return float32(f)
+		return floatValue(f)
 	}
-	var se models.StockEntry
-	if e.Var.D == 0 {
-		if e := global.Entries.FetchOne(id); e != nil {
-			se = *e
-		} else {
-			return 0
-		}
-	} else {
-		if e := global.Entries.FetchOldestWithin(id, time.Duration(e.Var.D) * time.Minute); e != nil {
-			se = *e
-		} else {
-			return 0
+	return e.Var.valueAt(id, c)
+}
+
+// Entry variables, whose values can be read as of any entry
+func (v variable) historic() bool {
+	return slices.Contains(entryVariables, v.T) || v.T == VarTicksToTarget
+}
+
+func (v variable) value(id uint) value {
+	return v.valueAt(id, nil)
+}
+
+// Value as of the cursor's record, or as of the latest entry when nil.
+// Variables without history are NaN in the past
+func (v variable) valueAt(id uint, c *cursor) value {
+	if v.Change != "" {
+		return v.change(id, c)
+	}
+	if !v.historic() && c != nil {
+		return floatValue(math.NaN())
+	}
+	if slices.Contains(candleVariables, v.T) {
+		return v.candleValue(id)
+	}
+	if spec, err := indicators.Parse(string(v.T)); err == nil {
+		if f, ok := global.Indicators.Value(id, spec); ok {
+			return floatValue(f)
 		}
+		return floatValue(math.NaN())
 	}
-	switch e.Var.T {
+	se, ok := v.entry(id, c)
+	if !ok {
+		// Unknown, like an indicator that is not ready
+		return floatValue(math.NaN())
+	}
+	switch v.T {
 	case VarLastPrice:
-		return se.GetLastPrice()
+		return priceValue(se.GetLastPrice())
 	case VarPreclosePrice:
-		return se.GetPreclosePrice()
+		return priceValue(se.GetPreclosePrice())
 	case VarPriceChange:
-		return float32(se.GetPriceChange())
+		return priceValue(se.GetPriceChange())
 	case VarTotalBoughtQuantity:
-		return float32(se.GetTotalBoughtQuantity())
+		return floatValue(float64(se.GetTotalBoughtQuantity()))
 	case VarTradeValue:
-		return float32(se.GetTradeValue())
+		return priceValue(se.GetTradeValue())
 	case VarBuyVolume:
-		return float32(se.GetBuyVolume())
+		return floatValue(float64(se.GetBuyVolume()))
 	case VarSellVolume:
-		return float32(se.GetSellVolume())
+		return floatValue(float64(se.GetSellVolume()))
 	case VarBuyRate:
-		return se.GetBuyRate()
+		return floatValue(float64(se.GetBuyRate()))
+	case VarPriceChangeTicks:
+		return floatValue(float64(models.Ticks.Between(se.GetPreclosePrice(), se.GetLastPrice())))
+	case VarTicksToTarget:
+		return floatValue(float64(models.Ticks.Between(se.GetLastPrice(), v.Target)))
+	default:
+		panic("invalid variable")
+	}
+}
+
+// The entry the variable reads, the latest or the oldest within D minutes,
+// as of the cursor's record or now
+func (v variable) entry(id uint, c *cursor) (models.StockEntry, bool) {
+	if c != nil {
+		return c.oldestWithin(v.D).Entry, true
+	}
+	var e *models.StockEntry
+	if v.D == 0 {
+		e = global.Entries.FetchOne(id)
+	} else {
+		e = global.Entries.FetchOldestWithin(id, time.Duration(v.D)*time.Minute)
+	}
+	if e == nil {
+		return models.StockEntry{}, false
+	}
+	return *e, true
+}
+
+// How much the variable moved from D minutes before to the latest entry, as
+// of the cursor's record or now
+func (v variable) change(id uint, c *cursor) value {
+	latest := variable{T: v.T, Target: v.Target}.valueAt(id, c)
+	old := variable{T: v.T, D: v.D, Target: v.Target}.valueAt(id, c)
+	if v.Change == ChangePercent {
+		if old.f == 0 {
+			return floatValue(math.NaN())
+		}
+		return floatValue((latest.f - old.f) / math.Abs(old.f) * 100)
+	}
+	if latest.exact && old.exact {
+		return priceValue(latest.price - old.price)
+	}
+	return floatValue(latest.f - old.f)
+}
+
+func (v variable) candleValue(id uint) value {
+	c, ok := global.Candles.Get(id, v.Resolution, int(v.Offset))
+	if !ok {
+		return floatValue(math.NaN())
+	}
+	switch v.T {
+	case VarCandleOpen:
+		return priceValue(c.Open)
+	case VarCandleHigh:
+		return priceValue(c.High)
+	case VarCandleLow:
+		return priceValue(c.Low)
+	case VarCandleClose:
+		return priceValue(c.Close)
+	case VarCandleVolume:
+		return floatValue(float64(c.Volume))
+	case VarCandleValue:
+		return priceValue(c.Value)
 	default:
 		panic("invalid variable")
 	}
@@ -152,37 +445,238 @@ func (r Rule) validate() error {
 	switch r.Cmp {
 	default:
 		return fmt.Errorf("%s is not a valid comparator", r.Cmp)
-	case CmpEquals, CmpNot, CmpGt, CmpGte, CmpLt, CmpLte:
+	case CmpEquals, CmpNot, CmpGt, CmpGte, CmpLt, CmpLte, CmpCrossesAbove, CmpCrossesBelow:
+	}
+	if r.For > 0 && r.Within > 0 {
+		return errors.New("a rule cannot both hold for a while and hold a number of times")
+	}
+	if (r.Times > 0) != (r.Within > 0) {
+		return errors.New("times and within must be set together")
+	}
+	if r.For > 0 && r.crossing() {
+		return fmt.Errorf("%s cannot hold for a while", r.Cmp)
+	}
+	if window := time.Duration(max(r.For, r.Within)) * time.Minute; window > global.DefaultMaxAge {
+		return fmt.Errorf("%s is longer than the %s of history kept", window, global.DefaultMaxAge)
+	}
+	if r.temporal() {
+		for _, e := range []eval{r.A, r.B} {
+			if !e.historic() {
+				return fmt.Errorf("%s has no history to compare over", e.Var.T)
+			}
+		}
 	}
 	return nil
 }
 
+func (r *Rule) crossing() bool {
+	return r.Cmp == CmpCrossesAbove || r.Cmp == CmpCrossesBelow
+}
+
+// Whether the rule looks back over the stock's history
+func (r *Rule) temporal() bool {
+	return r.crossing() || r.For > 0 || r.Within > 0
+}
+
 func (r *Rule) eval(id uint) bool {
-	a := r.A.float32(id)
-	b := r.B.float32(id)
-	switch r.Cmp {
+	return r.loosened(id, 0)
+}
+
+// Like eval with each comparison loosened by band, a fraction of its right
+// hand side. Negative bands tighten it
+func (r *Rule) loosened(id uint, band float64) bool {
+	if r.temporal() {
+		return r.history(id, band)
+	}
+	return r.holds(id, nil, r.Cmp, band)
+}
+
+// Whether A op B as of the cursor's record, or the latest entry when nil
+func (r *Rule) holds(id uint, c *cursor, op comparator, band float64) bool {
+	a, b := r.A.valueAt(id, c), r.B.valueAt(id, c)
+	// Missing data matches no comparison, not even !=
+	n, ok := a.compare(b)
+	if !ok {
+		return false
+	}
+	if band == 0 {
+		switch op {
+		case CmpEquals:
+			return n == 0
+		case CmpNot:
+			return n != 0
+		case CmpGt:
+			return n > 0
+		case CmpGte:
+			return n >= 0
+		case CmpLt:
+			return n < 0
+		case CmpLte:
+			return n <= 0
+		default:
+			panic("invalid comparator")
+		}
+	}
+	x, y := a.f, b.f
+	slack := band * math.Abs(y)
+	switch op {
 	case CmpEquals:
-		return a == b
+		if band < 0 {
+			return x == y
+		}
+		return math.Abs(x-y) <= slack
 	case CmpNot:
-		return a != b
+		if band > 0 {
+			return x != y
+		}
+		return !(math.Abs(x-y) <= -slack)
 	case CmpGt:
-		return a > b
+		return x > y-slack
 	case CmpGte:
-		return a >= b
+		return x >= y-slack
 	case CmpLt:
-		return a < b
+		return x < y+slack
 	case CmpLte:
-		return a <= b
+		return x <= y+slack
 	default:
 		panic("invalid comparator")
 	}
 }
 
+// A position in a stock's history that variables are read as of. Records
+// are only ever visited in order, so each duration's window start moves
+// forward with the position
+type cursor struct {
+	records []global.Record
+	i       int
+	// Index of the oldest record within each duration, in minutes
+	starts map[uint]int
+}
+
+// Oldest record within d minutes of the current one
+func (c *cursor) oldestWithin(d uint) *global.Record {
+	if d == 0 {
+		return &c.records[c.i]
+	}
+	from := c.records[c.i].Received.Add(-time.Duration(d) * time.Minute)
+	j := c.starts[d]
+	for c.records[j].Received.Before(from) {
+		j++
+	}
+	c.starts[d] = j
+	return &c.records[j]
+}
+
+// Longest duration the operands look back over, in minutes
+func (r *Rule) lookback() uint {
+	d := uint(0)
+	for _, e := range []eval{r.A, r.B} {
+		if e.Type == EvalVariable {
+			d = max(d, e.Var.D)
+		}
+	}
+	return d
+}
+
+// Evaluates a temporal rule over the entries in its window, along with the
+// entries before it that the first ones are compared with
+func (r *Rule) history(id uint, band float64) bool {
+	op, lead := r.Cmp, 1
+	switch r.Cmp {
+	case CmpCrossesAbove:
+		op, lead = CmpGt, 2
+	case CmpCrossesBelow:
+		op, lead = CmpLt, 2
+	}
+	start := global.Entries.Now().Add(-time.Duration(max(r.For, r.Within)) * time.Minute)
+	window := global.Entries.After(id, start, lead)
+	if len(window) == 0 {
+		return false
+	}
+	// Operands reading durations back also need the entries before the
+	// window. The window is the tail of what is fetched
+	records := window
+	if d := r.lookback(); d > 0 {
+		records = global.Entries.After(id, window[0].Received.Add(-time.Duration(d)*time.Minute-1), 0)
+	}
+	if len(records) < len(window) {
+		records = window
+	}
+	c := &cursor{records: records, starts: make(map[uint]int)}
+	offset := len(records) - len(window)
+	// Whether the comparison held, or for crossings started to hold, at
+	// each entry of the window
+	held := make([]bool, len(window))
+	prev := false
+	for i := range window {
+		c.i = offset + i
+		ok := r.holds(id, c, op, band)
+		held[i] = ok
+		if r.crossing() {
+			held[i] = ok && i > 0 && !prev
+		}
+		prev = ok
+	}
+	// Index of the first entry within the window
+	first := len(window)
+	for i, rec := range window {
+		if rec.Received.After(start) {
+			first = i
+			break
+		}
+	}
+	switch {
+	case r.For > 0:
+		// Entries only cover the window if one came before it
+		if first == 0 {
+			return false
+		}
+		return !slices.Contains(held[first-1:], false)
+	case r.Within > 0:
+		times := uint(0)
+		for _, ok := range held[first:] {
+			if ok {
+				times++
+			}
+		}
+		return times >= r.Times
+	default:
+		return held[len(held)-1]
+	}
+}
+
+// Whether the stock's latest entry satisfies the rules and the expression.
+// Alerts without either match every tick, unless they listen for events, in
+// which case they only fire on those
 func (a Alert) Eval(id uint) bool {
+	return a.eval(id, 0)
+}
+
+// Eval with every comparison loosened by band
+func (a Alert) eval(id uint, band float64) bool {
+	if len(a.Events) > 0 && len(a.Rules) == 0 && a.When == nil && a.Expr == nil {
+		return false
+	}
+	if !a.Scope.Includes(id) {
+		return false
+	}
+	if len(a.Phases) > 0 {
+		latest := global.Entries.FetchOne(id)
+		if latest == nil || !slices.Contains(a.Phases, latest.GetPhase()) {
+			return false
+		}
+	}
 	for _, rule := range a.Rules {
-		if !rule.eval(id) {
+		if !rule.loosened(id, band) {
 			return false
 		}
 	}
-	return true
+	if a.When != nil && !a.When.eval(id, band) {
+		return false
+	}
+	return a.Expr == nil || a.Expr.eval(id, band)
+}
+
+func (a Alert) Triggered(e models.MetadataEvent) bool {
+	return slices.Contains(a.Events, e.Kind) && a.Scope.Matches(e.Id, e.Stock())
 }
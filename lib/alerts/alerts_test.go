@@ -1,8 +1,16 @@
 package alerts_test
 
 import (
+	"bursa-alert/internal"
 	"bursa-alert/lib/alerts"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -40,6 +48,72 @@ const VALID = `
   tags:
     - "volume"
     - "high"
+
+- label: "Near Target"
+  rules:
+    - a:
+        type: "var"
+        value:
+          type: "ticks_to_target"
+          target: "1.20"
+      cmp: "<="
+      b:
+        type: "const"
+        value: "3"
+
+- label: "Breakout"
+  rules:
+    - a:
+        type: "var"
+        value: "last_price"
+      cmp: ">"
+      b:
+        type: "var"
+        value:
+          type: "candle_high"
+          resolution: "5m"
+          offset: 1
+
+- label: "Oversold"
+  rules:
+    - a:
+        type: "var"
+        value: "rsi(14, 5m)"
+      cmp: "<"
+      b:
+        type: "const"
+        value: "30"
+
+- label: "Expression"
+  expr: "last_price > 1.05 * preclose_price and (buy_rate >= 0.6 or candle_high(5m, 1) < last_price(5m))"
+
+- label: "Hysteresis"
+  expr: "last_price > 1.00"
+  trigger:
+    mode: "hysteresis"
+    band: 0.02
+    rearm: 5
+
+- label: "Sustained Breakout"
+  rules:
+    - a: {type: var, value: last_price}
+      cmp: crosses_above
+      b: {type: var, value: {type: last_price, duration: 30}}
+      times: 2
+      within: 60
+    - a: {type: var, value: {type: trade_value, duration: 15, change: percent}}
+      cmp: ">"
+      b: {type: const, value: "50"}
+      for: 5
+
+- label: "Scoped"
+  expr: "last_price > 1.00"
+  scope:
+    boards: ["ace"]
+    prefixes: ["MY"]
+    exclude:
+      tickers: ["MYEG"]
+      patterns: ["-W[A-Z]$"]
 `
 
 const INVALID = `
@@ -79,6 +153,121 @@ const INVALID = `
   tags:
     - "invalid"
 
+- label: "Invalid Phase"
+  phases:
+    - "overnight"
+  rules:
+    - a:
+        type: "var"
+        value: "last_price"
+      cmp: ">"
+      b:
+        type: "const"
+        value: "100"
+
+- label: "Missing Target"
+  rules:
+    - a:
+        type: "var"
+        value: "ticks_to_target"
+      cmp: "<="
+      b:
+        type: "const"
+        value: "3"
+
+- label: "Missing Resolution"
+  rules:
+    - a:
+        type: "var"
+        value: "candle_close"
+      cmp: ">"
+      b:
+        type: "const"
+        value: "1.00"
+
+- label: "Invalid Indicator"
+  rules:
+    - a:
+        type: "var"
+        value: "rsi(0, 5m)"
+      cmp: "<"
+      b:
+        type: "const"
+        value: "30"
+
+- label: "Invalid Expression"
+  expr: "last_price > rsi(0, 5m)"
+
+- label: "Empty Group"
+  when:
+    all:
+      - rule:
+          a: {type: var, value: last_price}
+          cmp: ">"
+          b: {type: const, value: "1"}
+      - any: []
+
+- label: "Cooldown Without Minutes"
+  expr: "last_price > 1.00"
+  trigger:
+    mode: "cooldown"
+
+- label: "Band Without Hysteresis"
+  expr: "last_price > 1.00"
+  trigger:
+    mode: "edge"
+    band: 0.02
+
+- label: "Invalid Board"
+  expr: "last_price > 1.00"
+  scope:
+    boards: ["leap", "gem"]
+
+- label: "Invalid Pattern"
+  expr: "last_price > 1.00"
+  scope:
+    exclude:
+      patterns: ["(unclosed"]
+
+- label: "Held Crossing"
+  rules:
+    - a: {type: var, value: last_price}
+      cmp: crosses_above
+      b: {type: const, value: "1"}
+      for: 5
+
+- label: "Times Without Window"
+  rules:
+    - a: {type: var, value: last_price}
+      cmp: ">"
+      b: {type: const, value: "1"}
+      times: 3
+
+- label: "Crossing Without History"
+  rules:
+    - a: {type: var, value: "rsi(14, 5m)"}
+      cmp: crosses_above
+      b: {type: const, value: "70"}
+
+- label: "Change Without Duration"
+  rules:
+    - a: {type: var, value: {type: last_price, change: percent}}
+      cmp: ">"
+      b: {type: const, value: "5"}
+
+- label: "Ambiguous Group"
+  when:
+    not:
+      rule:
+        a: {type: var, value: last_price}
+        cmp: ">"
+        b: {type: const, value: "1"}
+      any:
+        - rule:
+            a: {type: var, value: last_price}
+            cmp: "<"
+            b: {type: const, value: "1"}
+
 - label: "Invalid Constant"
   rules:
     - a:
@@ -93,6 +282,8 @@ const INVALID = `
 `
 
 func TestValid(t *testing.T) {
+	models.BoardCodes = models.BoardTable{"0259": models.BoardACE}
+	defer func() { models.BoardCodes = models.BoardTable{} }()
 	var a []alerts.Alert = make([]alerts.Alert, 0)
 	if err := yaml.Unmarshal([]byte(VALID), &a); err != nil {
 		panic(err)
@@ -115,3 +306,312 @@ func TestInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestExactPrices(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 1005, PreclosePrice: 1000, SellVolumeMorning: 1}))
+	var a []alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+- label: "Exact"
+  rules:
+    - a: {type: var, value: last_price}
+      cmp: "=="
+      b: {type: const, value: "1.005"}
+    - a: {type: var, value: price_change}
+      cmp: ">="
+      b: {type: const, value: "0.005"}
+    - a: {type: var, value: buy_rate}
+      cmp: "<"
+      b: {type: const, value: "0.1"}
+- label: "Exact expression"
+  expr: "last_price == 1.005 and last_price - preclose_price >= 0.005"
+`), &a); err != nil {
+		t.Fatal(err)
+	}
+	for _, alert := range a {
+		if err := alert.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !alert.Eval(1) {
+			t.Errorf("%s did not match", alert.Label)
+		}
+	}
+}
+
+func TestExpression(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 1100, PreclosePrice: 1000, BuyVolumeMorning: 7, SellVolumeMorning: 3}))
+	var a []alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+- label: "Breakout"
+  expr: "last_price > 1.05 * preclose_price and (buy_rate >= 0.6 or trade_value > 500000)"
+`), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !a[0].Eval(1) {
+		t.Error("expression did not match")
+	}
+
+	// Round trips keep the source and compile it again
+	var fromJSON, fromYAML, fromGob alerts.Alert
+	b, _ := json.Marshal(a[0])
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	y, _ := yaml.Marshal(a[0])
+	if err := yaml.Unmarshal(y, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(a[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewDecoder(&buf).Decode(&fromGob); err != nil {
+		t.Fatal(err)
+	}
+	for i, alert := range []alerts.Alert{fromJSON, fromYAML, fromGob} {
+		if alert.Expr == nil || alert.Expr.Source != a[0].Expr.Source || !alert.Eval(1) {
+			t.Errorf("expression lost in round trip %d: %+v", i, alert.Expr)
+		}
+	}
+}
+
+func TestMissingData(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 400}))
+	var a []alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+- label: "Expression"
+  expr: "last_price < 0.5 and preclose_price(5m) < 1"
+- label: "Expression not"
+  expr: "last_price != 0.5"
+- label: "Rule"
+  rules:
+    - {a: {type: var, value: last_price}, cmp: "<", b: {type: const, value: "0.5"}}
+- label: "Not"
+  rules:
+    - {a: {type: var, value: last_price}, cmp: "!=", b: {type: const, value: "0.5"}}
+`), &a); err != nil {
+		t.Fatal(err)
+	}
+	for _, alert := range a {
+		if err := alert.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !alert.Eval(1) {
+			t.Errorf("%s did not match a stock with data", alert.Label)
+		}
+		if alert.Eval(2) {
+			t.Errorf("%s matched a stock without data", alert.Label)
+		}
+	}
+}
+
+func TestWithoutRules(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 400}))
+	if !(alerts.Alert{Label: "Every tick"}).Eval(1) {
+		t.Error("alert without rules did not match")
+	}
+	events := alerts.Alert{Label: "Delisted", Events: []models.MetadataEventKind{models.EventDelisting}}
+	if events.Eval(1) {
+		t.Error("event alert without rules matched a tick")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 1100, PreclosePrice: 1000, BuyVolumeMorning: 7, SellVolumeMorning: 3}))
+	var a []alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+- label: "Any"
+  when:
+    any:
+      - rule: {a: {type: var, value: last_price}, cmp: "<", b: {type: const, value: "1"}}
+      - all:
+          - rule: {a: {type: var, value: buy_rate}, cmp: ">=", b: {type: const, value: "0.6"}}
+          - not:
+              rule: {a: {type: var, value: price_change}, cmp: "<", b: {type: const, value: "0"}}
+- label: "Not"
+  when:
+    not:
+      any:
+        - rule: {a: {type: var, value: last_price}, cmp: "==", b: {type: const, value: "1.10"}}
+- label: "Legacy"
+  rules:
+    - {a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}}
+    - {a: {type: var, value: buy_rate}, cmp: "<", b: {type: const, value: "0.5"}}
+`), &a); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, false} {
+		if err := a[i].Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := a[i].Eval(1); got != want {
+			t.Errorf("%s evaluated to %t", a[i].Label, got)
+		}
+	}
+	legacy := a[2]
+	if len(legacy.Rules) != 0 || legacy.When == nil || len(legacy.When.All) != 2 || legacy.When.All[1].Rule == nil {
+		t.Errorf("legacy rules not upgraded to an all group: %+v", legacy)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(a); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []alerts.Alert
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	for i := range a {
+		if decoded[i].Eval(1) != a[i].Eval(1) {
+			t.Errorf("%s changed in a gob round trip", a[i].Label)
+		}
+	}
+}
+
+func TestTemporal(t *testing.T) {
+	base := time.Date(2024, 6, 3, 10, 0, 0, 0, session.Location)
+	now := base
+	global.Entries.SetClock(func() time.Time { return now })
+	defer global.Entries.SetClock(time.Now)
+	defer global.Entries.Reset()
+
+	for _, tc := range []struct {
+		rule   string
+		prices []models.Price
+		want   bool
+	}{
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}}`, []models.Price{990, 1010}, true},
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}}`, []models.Price{1010, 1020}, false},
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}}`, []models.Price{1010}, false},
+		{`{a: {type: var, value: last_price}, cmp: crosses_below, b: {type: const, value: "1"}}`, []models.Price{1010, 990}, true},
+		// Held since the entry at the start of the window
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, for: 3}`, []models.Price{990, 1010, 1010, 1010, 1010}, true},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, for: 3}`, []models.Price{990, 990, 1010, 1010, 1010}, false},
+		// History shorter than the window
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, for: 3}`, []models.Price{1010, 1010}, false},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, times: 2, within: 10}`, []models.Price{1010, 990, 1010, 990}, true},
+		// Counts every entry it held at, not only when it started to hold
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, times: 2, within: 10}`, []models.Price{1010, 1020, 1030}, true},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, times: 1, within: 5}`, []models.Price{1010, 1010, 1010, 1010, 1010, 1010, 1010, 1010, 1010, 1010, 1010}, true},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, times: 2, within: 10}`, []models.Price{1010, 990, 990, 990}, false},
+		// Entries before the window are only read for durations
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: const, value: "1"}, times: 2, within: 2}`, []models.Price{1010, 1010, 990, 1010}, false},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: var, value: {type: last_price, duration: 2}}, for: 2}`, []models.Price{1000, 1010, 1020, 1030, 1040}, true},
+		{`{a: {type: var, value: last_price}, cmp: ">", b: {type: var, value: {type: last_price, duration: 2}}, for: 2}`, []models.Price{1000, 1010, 1020, 1005, 1040}, false},
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}, times: 2, within: 10}`, []models.Price{990, 1010, 990, 1010}, true},
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}, times: 2, within: 10}`, []models.Price{1010, 990, 1010}, false},
+		// Crossings before the window do not count
+		{`{a: {type: var, value: last_price}, cmp: crosses_above, b: {type: const, value: "1"}, times: 2, within: 2}`, []models.Price{990, 1010, 990, 1010}, false},
+		{`{a: {type: var, value: {type: last_price, duration: 5, change: percent}}, cmp: ">", b: {type: const, value: "1.5"}}`, []models.Price{1000, 1020}, true},
+		{`{a: {type: var, value: {type: last_price, duration: 5, change: absolute}}, cmp: "==", b: {type: const, value: "0.02"}}`, []models.Price{1000, 1020}, true},
+		// Rising at every entry for two minutes
+		{`{a: {type: var, value: {type: last_price, duration: 1, change: absolute}}, cmp: ">", b: {type: const, value: "0"}, for: 2}`, []models.Price{1000, 1010, 1020, 1030}, true},
+		{`{a: {type: var, value: {type: last_price, duration: 1, change: absolute}}, cmp: ">", b: {type: const, value: "0"}, for: 2}`, []models.Price{1000, 1010, 1010, 1030}, false},
+	} {
+		var a alerts.Alert
+		if err := yaml.Unmarshal([]byte("label: x\nrules: ["+tc.rule+"]"), &a); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		global.Entries.Reset()
+		for i, p := range tc.prices {
+			now = base.Add(time.Duration(i) * time.Minute)
+			global.Entries.PushAt(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: uint(p)}), now)
+		}
+		if got := a.Eval(1); got != tc.want {
+			t.Errorf("%s over %v evaluated to %t", tc.rule, tc.prices, got)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: 1060, PreclosePrice: 1000}))
+	var stored []alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+- label: "Milicents"
+  rules:
+    - {a: {type: var, value: price_change}, cmp: ">", b: {type: const, value: "50"}}
+- label: "Reversed"
+  when:
+    not:
+      rule: {a: {type: const, value: "70"}, cmp: "<", b: {type: var, value: price_change}}
+- label: "Current"
+  version: 1
+  rules:
+    - {a: {type: var, value: price_change}, cmp: ">", b: {type: const, value: "0.05"}}
+`), &stored); err != nil {
+		t.Fatal(err)
+	}
+	for i := range stored {
+		stored[i].Migrate()
+		stored[i].Upgrade()
+		if err := stored[i].Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !stored[i].Eval(1) {
+			t.Errorf("%s did not match a change of 0.06 after migrating", stored[i].Label)
+		}
+		if stored[i].Version != alerts.Version {
+			t.Errorf("%s left at version %d", stored[i].Label, stored[i].Version)
+		}
+	}
+}
+
+func TestMissingCandle(t *testing.T) {
+	defer global.Entries.Reset()
+	defer global.Candles.Reset()
+	global.Entries.Reset()
+	global.Candles.Reset()
+	var a alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+label: "Breakout"
+rules:
+  - a: {type: var, value: last_price}
+    cmp: ">"
+    b: {type: var, value: {type: candle_high, resolution: 5m, offset: 1}}
+`), &a); err != nil {
+		t.Fatal(err)
+	}
+	push := func(price uint, at time.Time) {
+		stock := global.Entries.PushAt(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: price}), at)
+		global.Candles.Add(stock, at)
+	}
+	open := time.Date(2024, 6, 3, 9, 0, 0, 0, session.Location)
+	push(1000, open)
+	if a.Eval(1) {
+		t.Error("fired on the first bar of the day without a previous bar")
+	}
+	var not alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+label: "Moved"
+rules:
+  - a: {type: var, value: last_price}
+    cmp: "!="
+    b: {type: var, value: {type: candle_high, resolution: 5m, offset: 1}}
+`), &not); err != nil {
+		t.Fatal(err)
+	}
+	if not.Eval(1) {
+		t.Error("!= fired on the first bar of the day without a previous bar")
+	}
+	push(1010, open.Add(5*time.Minute))
+	if !a.Eval(1) {
+		t.Error("did not fire above the previous bar's high")
+	}
+}
@@ -0,0 +1,192 @@
+package alerts
+
+import (
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/expr"
+	"bursa-alert/lib/indicators"
+	"bursa-alert/lib/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A condition in the expression language, stored as its source. Every
+// variable can be used bare, entry variables also as of a while ago with
+// last_price(5m), and candle, target and indicator variables as calls like
+// candle_high(5m, 1), ticks_to_target(1.20) and rsi(14, 5m)
+type Expression struct {
+	Source  string
+	program *expr.Program
+	err     error
+	// Loosened programs by band
+	loose *sync.Map
+}
+
+func NewExpression(src string) *Expression {
+	x := &Expression{Source: src, loose: new(sync.Map)}
+	x.program, x.err = expr.Compile(src, environment())
+	return x
+}
+
+func (x *Expression) Validate() error {
+	if x.err != nil {
+		return fmt.Errorf("expression %s failed to parse: %w", x.Source, x.err)
+	}
+	return nil
+}
+
+func (x *Expression) Eval(id uint) bool {
+	return x.program != nil && x.program.Eval(id)
+}
+
+func (x *Expression) eval(id uint, band float64) bool {
+	if band == 0 || x.program == nil {
+		return x.Eval(id)
+	}
+	p, ok := x.loose.Load(band)
+	if !ok {
+		p, _ = x.loose.LoadOrStore(band, x.program.Loosen(band))
+	}
+	return p.(*expr.Program).Eval(id)
+}
+
+func (x *Expression) MarshalText() ([]byte, error) {
+	return []byte(x.Source), nil
+}
+
+// Never fails so a malformed expression is reported by Validate
+func (x *Expression) UnmarshalText(b []byte) error {
+	*x = *NewExpression(string(b))
+	return nil
+}
+
+func (x *Expression) GobEncode() ([]byte, error) {
+	return x.MarshalText()
+}
+
+func (x *Expression) GobDecode(b []byte) error {
+	return x.UnmarshalText(b)
+}
+
+var (
+	envOnce sync.Once
+	env     *expr.Env
+)
+
+func environment() *expr.Env {
+	envOnce.Do(func() {
+		env = &expr.Env{
+			Variables: make(map[string]func(uint) float64),
+			Prices:    make(map[string]func(uint) (models.Price, bool)),
+			Functions: make(map[string]expr.Function),
+		}
+		for _, t := range entryVariables {
+			env.Variables[string(t)] = variable{T: t}.float
+			env.Prices[string(t)] = variable{T: t}.price
+			env.Functions[string(t)] = expr.Function{Params: []expr.Type{expr.TypeDuration}, Result: expr.TypeNumber, Bind: bindEntry(t)}
+		}
+		env.Functions[string(VarTicksToTarget)] = expr.Function{Params: []expr.Type{expr.TypeNumber}, Result: expr.TypeNumber, Bind: bindTarget}
+		for _, t := range candleVariables {
+			env.Functions[string(t)] = expr.Function{Params: []expr.Type{expr.TypeDuration, expr.TypeNumber}, Optional: 1, Result: expr.TypeNumber, Bind: bindCandle(t)}
+		}
+		for _, name := range indicators.Names {
+			params := make([]expr.Type, indicators.Arity(name)+1)
+			params[len(params)-1] = expr.TypeDuration
+			env.Functions[string(name)] = expr.Function{Params: params, Result: expr.TypeNumber, Bind: bindIndicator(name)}
+		}
+	})
+	return env
+}
+
+// NaN when the stock has no data for the variable, so comparisons with it
+// do not hold
+func (v variable) float(id uint) float64 {
+	return v.value(id).f
+}
+
+// The variable's value in milicents, when it is an exact price
+func (v variable) price(id uint) (models.Price, bool) {
+	x := v.value(id)
+	return x.price, x.exact
+}
+
+func bindEntry(t variableType) func([]expr.Term) (expr.Term, error) {
+	return func(args []expr.Term) (expr.Term, error) {
+		d, err := duration(args[0].Literal)
+		if err != nil {
+			return expr.Term{}, err
+		}
+		if d <= 0 || d%time.Minute != 0 {
+			return expr.Term{}, fmt.Errorf("%s is not a whole number of minutes", args[0].Literal)
+		}
+		return number(variable{T: t, D: uint(d / time.Minute)}), nil
+	}
+}
+
+func bindTarget(args []expr.Term) (expr.Term, error) {
+	if !args[0].IsLiteral() {
+		return expr.Term{}, errors.New("target must be a constant price")
+	}
+	target, err := models.ParsePrice(args[0].Literal)
+	if err != nil || target <= 0 {
+		return expr.Term{}, fmt.Errorf("%s is not a valid target price", args[0].Literal)
+	}
+	return number(variable{T: VarTicksToTarget, Target: target}), nil
+}
+
+func bindCandle(t variableType) func([]expr.Term) (expr.Term, error) {
+	return func(args []expr.Term) (expr.Term, error) {
+		v := variable{T: t, Resolution: candles.Resolution(args[0].Literal)}
+		if err := v.Resolution.Validate(); err != nil {
+			return expr.Term{}, err
+		}
+		if len(args) > 1 {
+			offset, err := strconv.ParseUint(args[1].Literal, 10, 32)
+			if err != nil {
+				return expr.Term{}, errors.New("offset must be a constant number of bars")
+			}
+			v.Offset = uint(offset)
+		}
+		return number(v), nil
+	}
+}
+
+func bindIndicator(name indicators.Name) func([]expr.Term) (expr.Term, error) {
+	return func(args []expr.Term) (expr.Term, error) {
+		literals := make([]string, len(args))
+		for i, arg := range args {
+			if !arg.IsLiteral() {
+				return expr.Term{}, errors.New("parameters must be constants")
+			}
+			literals[i] = arg.Literal
+		}
+		spec, err := indicators.Parse(fmt.Sprintf("%s(%s)", name, strings.Join(literals, ", ")))
+		if err != nil {
+			return expr.Term{}, err
+		}
+		return number(variable{T: variableType(spec.String())}), nil
+	}
+}
+
+func number(v variable) expr.Term {
+	return expr.Term{Type: expr.TypeNumber, Num: v.float, Price: v.price}
+}
+
+// Parses durations like 90s, 5m, 1h and 1d
+func duration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a valid duration", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration", s)
+	}
+	return d, nil
+}
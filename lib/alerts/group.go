@@ -0,0 +1,164 @@
+package alerts
+
+import (
+	"bursa-alert/lib/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A node of a rule tree. Exactly one field is set: All holds when every
+// child does, Any when at least one does, Not when its child does not, and
+// Rule is a single comparison
+type Group struct {
+	All  []Group `yaml:"all,omitempty" json:"all,omitempty"`
+	Any  []Group `yaml:"any,omitempty" json:"any,omitempty"`
+	Not  *Group  `yaml:"not,omitempty" json:"not,omitempty"`
+	Rule *Rule   `yaml:"rule,omitempty" json:"rule,omitempty"`
+}
+
+func AllOf(rules ...Rule) Group {
+	g := Group{All: make([]Group, len(rules))}
+	for i := range rules {
+		g.All[i] = Group{Rule: &rules[i]}
+	}
+	return g
+}
+
+func (g Group) validate() error {
+	set := 0
+	for _, ok := range []bool{g.All != nil, g.Any != nil, g.Not != nil, g.Rule != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("a group needs exactly one of all, any, not or rule")
+	}
+	switch {
+	case g.Rule != nil:
+		return g.Rule.validate()
+	case g.Not != nil:
+		if err := g.Not.validate(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+		return nil
+	}
+	op, children := "all", g.All
+	if g.Any != nil {
+		op, children = "any", g.Any
+	}
+	if len(children) == 0 {
+		return fmt.Errorf("%s group is empty", op)
+	}
+	for i, child := range children {
+		if err := child.validate(); err != nil {
+			return fmt.Errorf("%s %d: %w", op, i+1, err)
+		}
+	}
+	return nil
+}
+
+// Loosens comparisons by band, and tightens those under not
+func (g Group) eval(id uint, band float64) bool {
+	switch {
+	case g.Rule != nil:
+		return g.Rule.loosened(id, band)
+	case g.Not != nil:
+		return !g.Not.eval(id, -band)
+	case g.Any != nil:
+		for _, child := range g.Any {
+			if child.eval(id, band) {
+				return true
+			}
+		}
+		return false
+	default:
+		for _, child := range g.All {
+			if !child.eval(id, band) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Alert as written before rule groups, when every alert was a list of rules
+type alertFields Alert
+
+func (a *Alert) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*alertFields)(a)); err != nil {
+		return err
+	}
+	a.Upgrade()
+	return nil
+}
+
+func (a *Alert) UnmarshalYAML(n *yaml.Node) error {
+	if err := n.Decode((*alertFields)(a)); err != nil {
+		return err
+	}
+	a.Upgrade()
+	return nil
+}
+
+// Calls f with every rule in the tree
+func (g *Group) walk(f func(r *Rule)) {
+	switch {
+	case g.Rule != nil:
+		f(g.Rule)
+	case g.Not != nil:
+		g.Not.walk(f)
+	}
+	for i := range g.All {
+		g.All[i].walk(f)
+	}
+	for i := range g.Any {
+		g.Any[i].walk(f)
+	}
+}
+
+// Moves rules of the flat format into a single all group, alongside any
+// group the alert already has
+func (a *Alert) Upgrade() {
+	if len(a.Rules) == 0 {
+		return
+	}
+	g := AllOf(a.Rules...)
+	if a.When != nil {
+		g.All = append(g.All, *a.When)
+	}
+	a.When, a.Rules = &g, nil
+}
+
+// Current format of stored alerts
+const Version = 1
+
+// Converts an alert stored in an older format. Before version 1 prices were
+// compared in ringgit except price_change, which was in milicents
+func (a *Alert) Migrate() {
+	if a.Version < 1 {
+		convert := func(v, c *eval) {
+			if v.Type != EvalVariable || v.Var.T != VarPriceChange || c.Type != EvalConstant {
+				return
+			}
+			if f, err := strconv.ParseFloat(string(c.Var.T), 64); err == nil {
+				c.Var.T = variableType(strconv.FormatFloat(f/float64(models.Ringgit), 'f', -1, 64))
+			}
+		}
+		migrate := func(r *Rule) {
+			convert(&r.A, &r.B)
+			convert(&r.B, &r.A)
+		}
+		for i := range a.Rules {
+			migrate(&a.Rules[i])
+		}
+		if a.When != nil {
+			a.When.walk(migrate)
+		}
+	}
+	a.Version = Version
+}
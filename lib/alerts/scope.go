@@ -0,0 +1,156 @@
+package alerts
+
+import (
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A set of stocks. A stock is in it when any of the fields matches
+type Selector struct {
+	Ids []uint `yaml:"ids,omitempty" json:"ids,omitempty"`
+	// Tickers or names, ignoring case
+	Tickers    []string `yaml:"tickers,omitempty" json:"tickers,omitempty"`
+	Watchlists []string `yaml:"watchlists,omitempty" json:"watchlists,omitempty"`
+	// Boards the stocks trade on, such as ace, as listed in boards.yaml
+	Boards []models.Board `yaml:"boards,omitempty" json:"boards,omitempty"`
+	// Starts of tickers or names, ignoring case
+	Prefixes []string `yaml:"prefixes,omitempty" json:"prefixes,omitempty"`
+	// Regular expressions matching part of a ticker or name
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+}
+
+// Stocks an alert is evaluated for: those selected, or every stock when
+// nothing is, minus those excluded
+type Scope struct {
+	Selector `yaml:",inline"`
+	Exclude  Selector `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// Compiled patterns, shared by every alert using them
+var patterns sync.Map
+
+func pattern(p string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(p); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(p, re)
+	return re, nil
+}
+
+func (s Selector) empty() bool {
+	return len(s.Ids) == 0 && len(s.Tickers) == 0 && len(s.Watchlists) == 0 &&
+		len(s.Boards) == 0 && len(s.Prefixes) == 0 && len(s.Patterns) == 0
+}
+
+func (s Selector) validate() error {
+	for _, name := range s.Watchlists {
+		if _, ok := global.Watchlists.Get(name); !ok {
+			return fmt.Errorf("no watchlist named %s", name)
+		}
+	}
+	for _, b := range s.Boards {
+		if !b.Valid() {
+			return fmt.Errorf("%s is not a valid board", b)
+		}
+	}
+	if len(s.Boards) > 0 && len(models.BoardCodes) == 0 {
+		return errors.New("selecting by board needs boards.yaml in the config dir")
+	}
+	for _, p := range s.Patterns {
+		if _, err := pattern(p); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Whether the stock with id and metadata m is selected. Only ids can match
+// stocks without metadata
+func (s Selector) matches(id uint, m models.StockMetadata, known bool) bool {
+	for _, i := range s.Ids {
+		if i == id {
+			return true
+		}
+	}
+	if !known {
+		return false
+	}
+	named := func(stocks []string) bool {
+		for _, stock := range stocks {
+			if strings.EqualFold(stock, m.Ticker) || strings.EqualFold(stock, m.Name) {
+				return true
+			}
+		}
+		return false
+	}
+	if named(s.Tickers) {
+		return true
+	}
+	for _, name := range s.Watchlists {
+		if stocks, _ := global.Watchlists.Get(name); named(stocks) {
+			return true
+		}
+	}
+	if len(s.Boards) > 0 {
+		board := m.Board()
+		for _, b := range s.Boards {
+			if b == board {
+				return true
+			}
+		}
+	}
+	ticker, name := strings.ToUpper(m.Ticker), strings.ToUpper(m.Name)
+	for _, p := range s.Prefixes {
+		p = strings.ToUpper(p)
+		if strings.HasPrefix(ticker, p) || strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	for _, p := range s.Patterns {
+		// Patterns that fail to compile match nothing
+		if re, err := pattern(p); err == nil && (re.MatchString(m.Ticker) || re.MatchString(m.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Scope) validate() error {
+	if err := s.Selector.validate(); err != nil {
+		return err
+	}
+	if err := s.Exclude.validate(); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	return nil
+}
+
+// Whether the stock with id, as currently listed, is in scope
+func (s Scope) Includes(id uint) bool {
+	if s.Selector.empty() && s.Exclude.empty() {
+		return true
+	}
+	m, ok := global.Metadata.Get(id)
+	return s.includes(id, m, ok)
+}
+
+// Whether the stock with id and metadata m is in scope
+func (s Scope) Matches(id uint, m models.StockMetadata) bool {
+	return s.includes(id, m, true)
+}
+
+func (s Scope) includes(id uint, m models.StockMetadata, known bool) bool {
+	if !s.Selector.empty() && !s.Selector.matches(id, m, known) {
+		return false
+	}
+	return !s.Exclude.matches(id, m, known)
+}
@@ -0,0 +1,94 @@
+package alerts_test
+
+import (
+	"bursa-alert/lib/alerts"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestScope(t *testing.T) {
+	global.Metadata.Load(map[uint]models.StockMetadata{
+		1: {Name: "MAYBANK", Ticker: "1155", Id: 1},
+		2: {Name: "PBBANK", Ticker: "1295", Id: 2},
+		3: {Name: "CIMB", Ticker: "1023", Id: 3},
+		4: {Name: "SNS", Ticker: "0259", Id: 4},
+		// Moved from ACE to the Main Market, keeping its ACE-range code
+		5: {Name: "MYEG", Ticker: "0138", Id: 5},
+		6: {Name: "MYEG-WB", Ticker: "0138WB", Id: 6},
+		7: {Name: "TRADEPLUS", Ticker: "0822EA", Id: 7},
+	}, time.Now())
+	models.BoardCodes = models.BoardTable{
+		"1155": models.BoardMain, "1295": models.BoardMain, "1023": models.BoardMain,
+		"0138": models.BoardMain, "0259": models.BoardACE, "0822EA": models.BoardETF,
+	}
+	defer func() { models.BoardCodes = models.BoardTable{} }()
+	global.Watchlists.Load(map[string][]string{"banks": {"maybank", "1295", "CIMB"}})
+	defer global.Metadata.Reset()
+	defer global.Watchlists.Load(nil)
+
+	for _, tc := range []struct {
+		scope string
+		ids   []uint
+	}{
+		{"{}", []uint{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"{tickers: [MAYBANK, pbbank, '1023']}", []uint{1, 2, 3}},
+		{"{ids: [8]}", []uint{8}},
+		{"{watchlists: [banks]}", []uint{1, 2, 3}},
+		{"{boards: [ace]}", []uint{4}},
+		{"{boards: [main]}", []uint{1, 2, 3, 5, 6}},
+		{"{boards: [etf]}", []uint{7}},
+		{"{prefixes: [my]}", []uint{5, 6}},
+		{"{patterns: ['-W[A-Z]$']}", []uint{6}},
+		{"{watchlists: [banks], exclude: {tickers: [CIMB]}}", []uint{1, 2}},
+		{"{exclude: {boards: [main]}}", []uint{4, 7, 8}},
+	} {
+		var a alerts.Alert
+		if err := yaml.Unmarshal([]byte("label: x\nscope: "+tc.scope), &a); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		var got []uint
+		for id := uint(1); id <= 8; id++ {
+			if a.Scope.Includes(id) {
+				got = append(got, id)
+			}
+		}
+		if len(got) != len(tc.ids) {
+			t.Errorf("%s includes %v, expected %v", tc.scope, got, tc.ids)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.ids[i] {
+				t.Errorf("%s includes %v, expected %v", tc.scope, got, tc.ids)
+				break
+			}
+		}
+	}
+
+	var a alerts.Alert
+	if err := yaml.Unmarshal([]byte("label: x\nscope: {watchlists: [missing]}"), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err == nil {
+		t.Error("unknown watchlist accepted")
+	}
+	models.BoardCodes = models.BoardTable{}
+	if err := yaml.Unmarshal([]byte("label: x\nscope: {boards: [ace]}"), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err == nil {
+		t.Error("board selector accepted without a board table")
+	}
+
+	// Delisted stocks are matched by their last metadata
+	a = alerts.Alert{Events: []models.MetadataEventKind{models.EventDelisting}, Scope: alerts.Scope{Selector: alerts.Selector{Tickers: []string{"OLD"}}}}
+	if !a.Triggered(models.MetadataEvent{Kind: models.EventDelisting, Id: 9, Old: models.StockMetadata{Name: "OLD", Ticker: "9999", Id: 9}}) {
+		t.Error("delisting out of scope")
+	}
+}
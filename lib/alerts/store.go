@@ -0,0 +1,91 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+var ErrIndex = errors.New("index out of range")
+
+// The alert list, shared between the routes editing it and the loop
+// evaluating it. Edits reset the trigger states of the alerts they touch
+// while holding the list, so evaluation never sees a new alert with the
+// state of the one it replaced
+type Store struct {
+	mu       sync.RWMutex
+	alerts   []Alert
+	triggers *Triggers
+}
+
+func NewStore(alertList []Alert, triggers *Triggers) *Store {
+	return &Store{alerts: slices.Clone(alertList), triggers: triggers}
+}
+
+// A copy of every alert
+func (s *Store) All() []Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.alerts)
+}
+
+// Calls f for every alert, holding off edits until it returns
+func (s *Store) Range(f func(Alert)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.alerts {
+		f(a)
+	}
+}
+
+// Appends a, which must be validated
+func (s *Store) Add(a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.labelFree(a.Label, -1); err != nil {
+		return err
+	}
+	s.triggers.Reset(a.Label)
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+// Replaces the alert at i with a, which must be validated. The replacement
+// starts out armed
+func (s *Store) Replace(i int, a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.alerts) {
+		return ErrIndex
+	}
+	if err := s.labelFree(a.Label, i); err != nil {
+		return err
+	}
+	s.triggers.Reset(s.alerts[i].Label)
+	s.triggers.Reset(a.Label)
+	s.alerts[i] = a
+	return nil
+}
+
+func (s *Store) Delete(i int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.alerts) {
+		return ErrIndex
+	}
+	s.triggers.Reset(s.alerts[i].Label)
+	s.alerts = slices.Delete(s.alerts, i, i+1)
+	return nil
+}
+
+// Fails when an alert other than the one at except is labelled label.
+// Trigger states tell alerts apart by label. Must hold s.mu
+func (s *Store) labelFree(label string, except int) error {
+	for i, a := range s.alerts {
+		if i != except && a.Label == label {
+			return fmt.Errorf("an alert labelled %s already exists", label)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package alerts_test
+
+import (
+	"bursa-alert/lib/alerts"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/session"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, session.Location)
+	triggers := alerts.NewTriggers(session.New())
+	a := triggerAlert(t, "{mode: edge}")
+	store := alerts.NewStore([]alerts.Alert{a}, triggers)
+
+	if err := store.Add(a); err == nil {
+		t.Error("added a second alert with the same label")
+	}
+	if err := store.Replace(1, a); !errors.Is(err, alerts.ErrIndex) {
+		t.Errorf("replaced out of range: %v", err)
+	}
+
+	// Replacing an alert re-arms it
+	fire(triggers, a, start, 1010)
+	if err := store.Replace(0, a); err != nil {
+		t.Fatal(err)
+	}
+	if fired := fire(triggers, a, start, 1020); !fired[0] {
+		t.Error("replaced edge trigger did not fire")
+	}
+
+	// Edits are safe alongside evaluation
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			store.Range(func(a alerts.Alert) {
+				triggers.Check(a, 1, start)
+			})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		b := a
+		b.Label = string(rune('a' + i%26))
+		_ = store.Add(b)
+	}
+	wg.Wait()
+	if n := len(store.All()); n != 27 {
+		t.Errorf("expected 27 alerts, got %d", n)
+	}
+	if err := store.Delete(0); err != nil {
+		t.Fatal(err)
+	}
+	if all := store.All(); all[0].Label != "a" {
+		t.Errorf("deleted the wrong alert: %v", all[0].Label)
+	}
+}
@@ -0,0 +1,202 @@
+package alerts
+
+import (
+	"bursa-alert/lib/session"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type TriggerMode string
+
+const (
+	// Fires on every entry while the condition holds
+	TriggerLevel TriggerMode = "level"
+	// Fires when the condition becomes true
+	TriggerEdge TriggerMode = "edge"
+	// Fires at most once per cooldown while the condition holds
+	TriggerCooldown TriggerMode = "cooldown"
+	// Fires at most once per trading session
+	TriggerSession TriggerMode = "session"
+	// Fires when the condition becomes true, re-arming only once it has
+	// been false beyond the band or for the re-arm time
+	TriggerHysteresis TriggerMode = "hysteresis"
+)
+
+var TriggerModes = []TriggerMode{TriggerLevel, TriggerEdge, TriggerCooldown, TriggerSession, TriggerHysteresis}
+
+// When an alert whose condition holds notifies. The zero value is level
+// triggered
+type Trigger struct {
+	Mode TriggerMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Minutes between notifications in cooldown mode
+	Cooldown uint `yaml:"cooldown,omitempty" json:"cooldown,omitempty"`
+	// Fraction the condition's comparisons are loosened by while waiting to
+	// re-arm, so last_price > 1.00 with a band of 0.02 re-arms at 0.98
+	Band float64 `yaml:"band,omitempty" json:"band,omitempty"`
+	// Minutes the condition must stay false before re-arming
+	Rearm uint `yaml:"rearm,omitempty" json:"rearm,omitempty"`
+}
+
+func (t Trigger) validate() error {
+	switch t.Mode {
+	case "", TriggerLevel, TriggerEdge, TriggerSession:
+	case TriggerCooldown:
+		if t.Cooldown == 0 {
+			return errors.New("cooldown trigger needs a cooldown")
+		}
+	case TriggerHysteresis:
+		if t.Band == 0 && t.Rearm == 0 {
+			return errors.New("hysteresis trigger needs a band or a re-arm time")
+		}
+	default:
+		return fmt.Errorf("%s is not a valid trigger mode", t.Mode)
+	}
+	if t.Mode != TriggerHysteresis && (t.Band != 0 || t.Rearm != 0) {
+		return errors.New("band and re-arm time only apply to hysteresis triggers")
+	}
+	if t.Band < 0 || t.Band >= 1 {
+		return fmt.Errorf("band %g is not between 0 and 1", t.Band)
+	}
+	return nil
+}
+
+// Identifies the firing state of an alert for a stock. Alerts are told apart
+// by label, which must be unique
+type TriggerKey struct {
+	Alert string
+	Id    uint
+}
+
+type TriggerState struct {
+	LastFired time.Time
+	// Set once an edge or hysteresis trigger fires, until it re-arms
+	Latched bool
+	// Since when the condition has been false beyond the band
+	FalseSince time.Time
+	// Start of the session the alert last fired in
+	Session time.Time
+}
+
+// Firing state of every alert for every stock
+type Triggers struct {
+	Calendar *session.Calendar
+
+	mu     sync.Mutex
+	states map[TriggerKey]*TriggerState
+}
+
+func NewTriggers(calendar *session.Calendar) *Triggers {
+	return &Triggers{Calendar: calendar, states: make(map[TriggerKey]*TriggerState)}
+}
+
+// Evaluates the alert for the stock at now and returns whether it fires
+func (t *Triggers) Check(a Alert, id uint, now time.Time) bool {
+	match := a.Eval(id)
+	mode := a.Trigger.Mode
+	if mode == "" || mode == TriggerLevel {
+		return match
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := TriggerKey{a.Label, id}
+	s := t.states[k]
+	if s == nil {
+		if !match {
+			return false
+		}
+		s = &TriggerState{}
+		t.states[k] = s
+	}
+
+	switch mode {
+	case TriggerCooldown:
+		if !match || (!s.LastFired.IsZero() && now.Sub(s.LastFired) < time.Duration(a.Trigger.Cooldown)*time.Minute) {
+			return false
+		}
+	case TriggerSession:
+		start := t.session(now)
+		if !match || s.Session.Equal(start) {
+			return false
+		}
+		s.Session = start
+	default:
+		if match {
+			s.FalseSince = time.Time{}
+			if s.Latched {
+				return false
+			}
+			s.Latched = true
+			break
+		}
+		if s.Latched {
+			t.rearm(a, id, s, now)
+		}
+		return false
+	}
+	s.LastFired = now
+	return true
+}
+
+// Unlatches the state once the condition has been false beyond the band for
+// the re-arm time. Must hold t.mu
+func (t *Triggers) rearm(a Alert, id uint, s *TriggerState, now time.Time) {
+	if a.Trigger.Band > 0 && a.eval(id, a.Trigger.Band) {
+		s.FalseSince = time.Time{}
+		return
+	}
+	if s.FalseSince.IsZero() {
+		s.FalseSince = now
+	}
+	if now.Sub(s.FalseSince) >= time.Duration(a.Trigger.Rearm)*time.Minute {
+		s.Latched, s.FalseSince = false, time.Time{}
+	}
+}
+
+// Start of the trading session at is in, or of its day outside sessions
+func (t *Triggers) session(at time.Time) time.Time {
+	if open, _, ok := t.Calendar.Session(at); ok {
+		return open
+	}
+	return t.Calendar.Day(at)
+}
+
+func (t *Triggers) States() map[TriggerKey]TriggerState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[TriggerKey]TriggerState, len(t.states))
+	for k, s := range t.states {
+		out[k] = *s
+	}
+	return out
+}
+
+// Replaces every state, such as with states saved before a restart. States
+// of alerts not in alertList are dropped
+func (t *Triggers) Load(states map[TriggerKey]TriggerState, alertList []Alert) {
+	labels := make(map[string]bool, len(alertList))
+	for _, a := range alertList {
+		labels[a.Label] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.states = make(map[TriggerKey]*TriggerState, len(states))
+	for k, s := range states {
+		if labels[k.Alert] {
+			t.states[k] = &s
+		}
+	}
+}
+
+// Forgets the state of the alert labelled label for every stock, such as when
+// it is replaced or deleted
+func (t *Triggers) Reset(label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.states {
+		if k.Alert == label {
+			delete(t.states, k)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package alerts_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/alerts"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func triggerAlert(t *testing.T, trigger string) alerts.Alert {
+	t.Helper()
+	var a alerts.Alert
+	if err := yaml.Unmarshal([]byte(`
+label: "Above"
+expr: "last_price > 1.00"
+trigger: `+trigger), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Feeds prices a minute apart from the 10:00 open and returns which fired
+func fire(triggers *alerts.Triggers, a alerts.Alert, start time.Time, prices ...models.Price) []bool {
+	fired := make([]bool, len(prices))
+	for i, p := range prices {
+		global.Entries.Push(models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: uint(p)}))
+		fired[i] = triggers.Check(a, 1, start.Add(time.Duration(i)*time.Minute))
+	}
+	return fired
+}
+
+func TestTriggers(t *testing.T) {
+	global.Entries.Reset()
+	defer global.Entries.Reset()
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, session.Location)
+	for _, tc := range []struct {
+		trigger string
+		prices  []models.Price
+		fired   []bool
+	}{
+		{"{}", []models.Price{1010, 1020, 990}, []bool{true, true, false}},
+		{"{mode: edge}", []models.Price{1010, 1020, 990, 1010}, []bool{true, false, false, true}},
+		{"{mode: cooldown, cooldown: 2}", []models.Price{1010, 1010, 1010, 1010}, []bool{true, false, true, false}},
+		{"{mode: session}", []models.Price{1010, 990, 1010}, []bool{true, false, false}},
+		// Re-arms only below 0.98
+		{"{mode: hysteresis, band: 0.02}", []models.Price{1010, 990, 1010, 980, 1010}, []bool{true, false, false, false, true}},
+		// Re-arms after two minutes below
+		{"{mode: hysteresis, rearm: 2}", []models.Price{1010, 990, 1010, 990, 990, 990, 1010}, []bool{true, false, false, false, false, false, true}},
+	} {
+		a := triggerAlert(t, tc.trigger)
+		got := fire(alerts.NewTriggers(session.New()), a, start, tc.prices...)
+		for i := range got {
+			if got[i] != tc.fired[i] {
+				t.Errorf("%s over %v fired %v, expected %v", tc.trigger, tc.prices, got, tc.fired)
+				break
+			}
+		}
+	}
+
+	// A new session fires again
+	triggers := alerts.NewTriggers(session.New())
+	a := triggerAlert(t, "{mode: session}")
+	fire(triggers, a, start, 1010)
+	if fired := fire(triggers, a, start.Add(5*time.Hour), 1010); !fired[0] {
+		t.Error("session trigger did not fire in the afternoon session")
+	}
+
+	// State survives a save and load
+	a = triggerAlert(t, "{mode: edge}")
+	fire(triggers, a, start, 1010)
+	restored := alerts.NewTriggers(session.New())
+	restored.Load(triggers.States(), []alerts.Alert{a})
+	if fired := fire(restored, a, start, 1020); fired[0] {
+		t.Error("restored edge trigger fired again")
+	}
+
+	// Replacing the alert re-arms it
+	restored.Reset(a.Label)
+	if fired := fire(restored, a, start, 1020); !fired[0] {
+		t.Error("reset edge trigger did not fire")
+	}
+
+	// States of alerts that no longer exist are dropped
+	pruned := alerts.NewTriggers(session.New())
+	pruned.Load(restored.States(), []alerts.Alert{{Label: "Other"}})
+	if states := pruned.States(); len(states) != 0 {
+		t.Errorf("kept states of deleted alerts: %v", states)
+	}
+}
@@ -0,0 +1,230 @@
+// OHLCV bars built from the merged entry stream
+package candles
+
+import (
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Resolution string
+
+const (
+	Res1m    Resolution = "1m"
+	Res5m    Resolution = "5m"
+	Res15m   Resolution = "15m"
+	Res1h    Resolution = "1h"
+	ResDaily Resolution = "1d"
+)
+
+var Resolutions = []Resolution{Res1m, Res5m, Res15m, Res1h, ResDaily}
+
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Res1m:
+		return time.Minute
+	case Res5m:
+		return 5 * time.Minute
+	case Res15m:
+		return 15 * time.Minute
+	case Res1h:
+		return time.Hour
+	case ResDaily:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+func (r Resolution) Validate() error {
+	if r.Duration() == 0 {
+		return fmt.Errorf("%s is not a valid resolution", r)
+	}
+	return nil
+}
+
+type Candle struct {
+	Start time.Time    `json:"start"`
+	Open  models.Price `json:"open"`
+	High  models.Price `json:"high"`
+	Low   models.Price `json:"low"`
+	Close models.Price `json:"close"`
+	// Traded during the bar, in the units of the cumulative buy and sell volumes
+	Volume uint `json:"volume"`
+	// Volume priced at the ticks that reported it. Volume moved into an
+	// earlier bar by a late tick keeps its value in the later one
+	Value models.Price `json:"value"`
+	Ticks int          `json:"ticks"`
+}
+
+// A bar being built. Volume is derived from the day's cumulative volume, so
+// ticks arriving late or twice do not count it again
+type bar struct {
+	Candle
+	openAt, closeAt time.Time
+	// Highest cumulative volume of the day seen in the bar
+	cumulative uint
+}
+
+// Candles at every resolution for every stock
+type Builder struct {
+	Calendar *session.Calendar
+	// Bars kept per stock and resolution
+	MaxBars int
+
+	mu     sync.RWMutex
+	series map[key][]*bar
+}
+
+type key struct {
+	id  uint
+	res Resolution
+}
+
+// An updated candle, as sent to subscribers
+type Update struct {
+	Id         uint       `json:"id"`
+	Resolution Resolution `json:"resolution"`
+	Candle     Candle     `json:"candle"`
+}
+
+func NewBuilder(calendar *session.Calendar, maxBars int) *Builder {
+	return &Builder{Calendar: calendar, MaxBars: maxBars, series: make(map[key][]*bar)}
+}
+
+// Start of the bar t falls in. Intraday bars are anchored at the open of the
+// trading session, so none spans the lunch break and pre-open ticks join the
+// first bar. Outside sessions they are anchored at midnight
+func (b *Builder) Start(t time.Time, res Resolution) time.Time {
+	day := b.Calendar.Day(t)
+	if res == ResDaily {
+		return day
+	}
+	d := res.Duration()
+	open, _, ok := b.Calendar.Session(t)
+	if !ok {
+		return day.Add(t.Sub(day).Truncate(d))
+	}
+	if t.Before(open) {
+		return open
+	}
+	return open.Add(t.Sub(open).Truncate(d))
+}
+
+// Adds an entry received at t and returns the candles it changed
+func (b *Builder) Add(e models.StockEntry, t time.Time) []Update {
+	price := e.GetLastPrice()
+	if price <= 0 {
+		return nil
+	}
+	cumulative := e.GetBuyVolume() + e.GetSellVolume()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	updates := make([]Update, 0, len(Resolutions))
+	for _, res := range Resolutions {
+		k := key{e.GetIndex(), res}
+		bars, i, ok := b.bar(k, b.Start(t, res))
+		if !ok {
+			continue
+		}
+		br := bars[i]
+		if br.Ticks == 0 || t.Before(br.openAt) {
+			br.Open, br.openAt = price, t
+		}
+		if br.Ticks == 0 || !t.Before(br.closeAt) {
+			br.Close, br.closeAt = price, t
+		}
+		if br.Ticks == 0 || price > br.High {
+			br.High = price
+		}
+		if br.Ticks == 0 || price < br.Low {
+			br.Low = price
+		}
+		br.Ticks++
+		if base := max(br.cumulative, b.previous(bars, i)); cumulative > base {
+			br.Value += price.Mul(int64(cumulative - base))
+		}
+		br.cumulative = max(br.cumulative, cumulative)
+		updates = append(updates, Update{Id: k.id, Resolution: res, Candle: b.candle(bars, i)})
+	}
+	return updates
+}
+
+// Finds or inserts the bar starting at start. False if it would be older
+// than every bar kept. Must hold b.mu
+func (b *Builder) bar(k key, start time.Time) ([]*bar, int, bool) {
+	bars := b.series[k]
+	i := sort.Search(len(bars), func(i int) bool {
+		return !bars[i].Start.Before(start)
+	})
+	if i < len(bars) && bars[i].Start.Equal(start) {
+		return bars, i, true
+	}
+	full := b.MaxBars > 0 && len(bars) >= b.MaxBars
+	if full && i == 0 {
+		return nil, 0, false
+	}
+	bars = append(bars, nil)
+	copy(bars[i+1:], bars[i:])
+	bars[i] = &bar{Candle: Candle{Start: start}}
+	if full {
+		// Copy so the dropped bars can be collected
+		bars = append([]*bar(nil), bars[1:]...)
+		i--
+	}
+	b.series[k] = bars
+	return bars, i, true
+}
+
+// Cumulative volume at the end of the bar before i on the same day
+func (b *Builder) previous(bars []*bar, i int) uint {
+	if i == 0 || !b.Calendar.Day(bars[i-1].Start).Equal(b.Calendar.Day(bars[i].Start)) {
+		return 0
+	}
+	return bars[i-1].cumulative
+}
+
+func (b *Builder) candle(bars []*bar, i int) Candle {
+	c := bars[i].Candle
+	if prev := b.previous(bars, i); bars[i].cumulative > prev {
+		c.Volume = bars[i].cumulative - prev
+	}
+	return c
+}
+
+// The last n candles, oldest first. Zero n returns every candle kept
+func (b *Builder) Series(id uint, res Resolution, n int) []Candle {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bars := b.series[key{id, res}]
+	start := 0
+	if n > 0 && len(bars) > n {
+		start = len(bars) - n
+	}
+	out := make([]Candle, 0, len(bars)-start)
+	for i := start; i < len(bars); i++ {
+		out = append(out, b.candle(bars, i))
+	}
+	return out
+}
+
+// The candle offset bars before the latest one
+func (b *Builder) Get(id uint, res Resolution, offset int) (Candle, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bars := b.series[key{id, res}]
+	i := len(bars) - 1 - offset
+	if i < 0 || offset < 0 {
+		return Candle{}, false
+	}
+	return b.candle(bars, i), true
+}
+
+func (b *Builder) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.series = make(map[key][]*bar)
+}
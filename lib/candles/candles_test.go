@@ -0,0 +1,91 @@
+package candles_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"testing"
+	"time"
+)
+
+func at(hour, minute, second int) time.Time {
+	return time.Date(2024, 6, 3, hour, minute, second, 0, session.Location)
+}
+
+func entry(price models.Price, volume uint) models.StockEntry {
+	return models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: uint(price), BuyVolumeMorning: volume})
+}
+
+func TestStart(t *testing.T) {
+	b := candles.NewBuilder(session.New(), 0)
+	for _, tc := range []struct {
+		t     time.Time
+		res   candles.Resolution
+		start time.Time
+	}{
+		{at(9, 7, 30), candles.Res5m, at(9, 5, 0)},
+		// Pre-open ticks join the first bar
+		{at(8, 45, 0), candles.Res1m, at(9, 0, 0)},
+		// Afternoon bars are anchored at the 14:30 open, not on the hour
+		{at(15, 10, 0), candles.Res1h, at(14, 30, 0)},
+		{at(14, 10, 0), candles.Res15m, at(14, 30, 0)},
+		// Outside sessions bars align to the clock
+		{at(12, 47, 0), candles.Res15m, at(12, 45, 0)},
+		{at(16, 59, 0), candles.ResDaily, at(0, 0, 0)},
+	} {
+		if start := b.Start(tc.t, tc.res); !start.Equal(tc.start) {
+			t.Errorf("%s bar at %s expected to start at %s, got %s", tc.res, tc.t, tc.start, start)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	b := candles.NewBuilder(session.New(), 0)
+	b.Add(entry(100*models.Sen, 10), at(9, 0, 10))
+	b.Add(entry(105*models.Sen, 15), at(9, 0, 30))
+	// Arrives late, after a tick from the next minute
+	b.Add(entry(110*models.Sen, 30), at(9, 1, 5))
+	b.Add(entry(95*models.Sen, 20), at(9, 0, 50))
+
+	series := b.Series(1, candles.Res1m, 0)
+	if len(series) != 2 {
+		t.Fatalf("expected 2 candles, got %d", len(series))
+	}
+	first := series[0]
+	if first.Open != 100*models.Sen || first.High != 105*models.Sen || first.Low != 95*models.Sen || first.Close != 95*models.Sen {
+		t.Errorf("unexpected OHLC %+v", first)
+	}
+	if first.Volume != 20 || first.Ticks != 3 {
+		t.Errorf("unexpected volume %d over %d ticks", first.Volume, first.Ticks)
+	}
+	// The late tick's volume moves out of the second bar
+	if second := series[1]; second.Volume != 10 || second.Close != 110*models.Sen {
+		t.Errorf("unexpected second candle %+v", second)
+	}
+
+	daily, ok := b.Get(1, candles.ResDaily, 0)
+	if !ok || daily.Volume != 30 || daily.Open != 100*models.Sen || daily.Close != 110*models.Sen {
+		t.Errorf("unexpected daily candle %+v", daily)
+	}
+	if _, ok := b.Get(1, candles.Res1m, 2); ok {
+		t.Error("candle beyond the series returned")
+	}
+	if prev, _ := b.Get(1, candles.Res1m, 1); !prev.Start.Equal(at(9, 0, 0)) {
+		t.Errorf("offset 1 returned the candle at %s", prev.Start)
+	}
+}
+
+func TestMaxBars(t *testing.T) {
+	b := candles.NewBuilder(session.New(), 2)
+	for minute := range 3 {
+		b.Add(entry(models.Ringgit, uint(minute+1)), at(10, minute, 0))
+	}
+	if updates := b.Add(entry(models.Ringgit, 1), at(9, 30, 0)); len(updates) != len(candles.Resolutions)-1 {
+		t.Errorf("tick older than the kept 1m bars not dropped: %+v", updates)
+	}
+	series := b.Series(1, candles.Res1m, 0)
+	if len(series) != 2 || !series[0].Start.Equal(at(10, 1, 0)) {
+		t.Errorf("unexpected series %+v", series)
+	}
+}
@@ -0,0 +1,210 @@
+// Catalogues the message types and field codes seen on the upstream feed
+package discovery
+
+import (
+	"bursa-alert/internal"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Distinct example values kept per field
+const maxExamples = 5
+
+type Catalogue struct {
+	mu       sync.Mutex
+	messages map[string]*MessageStats
+}
+
+type MessageStats struct {
+	Count  int                    `json:"count"`
+	Fields map[string]*FieldStats `json:"fields,omitempty"`
+}
+
+type FieldStats struct {
+	// Name from the field registry, if known
+	Name  string `json:"name,omitempty"`
+	Count int    `json:"count"`
+	// Share of the message type's messages carrying the field
+	Frequency float64 `json:"frequency"`
+	// JSON kinds seen: number, string, list, object, bool, null
+	Kinds []string `json:"kinds"`
+	// Range of numbers, or of lengths for strings and lists
+	Min      *float64          `json:"min,omitempty"`
+	Max      *float64          `json:"max,omitempty"`
+	Examples []json.RawMessage `json:"examples"`
+}
+
+func New() *Catalogue {
+	return &Catalogue{messages: make(map[string]*MessageStats)}
+}
+
+// Records a message. Usable as a ws.Observer
+func (c *Catalogue) Observe(mt string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.messages[mt]
+	if !ok {
+		m = &MessageStats{Fields: make(map[string]*FieldStats)}
+		c.messages[mt] = m
+	}
+	m.Count++
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+	for key, v := range fields {
+		f, ok := m.Fields[key]
+		if !ok {
+			f = &FieldStats{}
+			if code, err := strconv.Atoi(key); err == nil {
+				f.Name = internal.Fields[code].Name
+			}
+			m.Fields[key] = f
+		}
+		f.observe(v)
+	}
+}
+
+func (f *FieldStats) observe(v json.RawMessage) {
+	f.Count++
+	kind, size := describe(v)
+	if !slices.Contains(f.Kinds, kind) {
+		f.Kinds = append(f.Kinds, kind)
+		slices.Sort(f.Kinds)
+	}
+	if size != nil {
+		if f.Min == nil || *size < *f.Min {
+			f.Min = size
+		}
+		if f.Max == nil || *size > *f.Max {
+			f.Max = size
+		}
+	}
+	if len(f.Examples) < maxExamples && !slices.ContainsFunc(f.Examples, func(e json.RawMessage) bool {
+		return bytes.Equal(e, v)
+	}) {
+		f.Examples = append(f.Examples, append(json.RawMessage(nil), v...))
+	}
+}
+
+func describe(v json.RawMessage) (string, *float64) {
+	var x any
+	if err := json.Unmarshal(v, &x); err != nil {
+		return "invalid", nil
+	}
+	switch x := x.(type) {
+	case float64:
+		return "number", &x
+	case string:
+		n := float64(len(x))
+		return "string", &n
+	case []any:
+		n := float64(len(x))
+		return "list", &n
+	case map[string]any:
+		return "object", nil
+	case bool:
+		return "bool", nil
+	default:
+		return "null", nil
+	}
+}
+
+func (c *Catalogue) snapshot() map[string]MessageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]MessageStats, len(c.messages))
+	for mt, m := range c.messages {
+		stats := MessageStats{Count: m.Count, Fields: make(map[string]*FieldStats, len(m.Fields))}
+		for key, f := range m.Fields {
+			copied := *f
+			copied.Frequency = float64(f.Count) / float64(m.Count)
+			stats.Fields[key] = &copied
+		}
+		out[mt] = stats
+	}
+	return out
+}
+
+func (c *Catalogue) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.snapshot())
+}
+
+func (c *Catalogue) WriteMarkdown(w io.Writer) error {
+	messages := c.snapshot()
+	mts := make([]string, 0, len(messages))
+	for mt := range messages {
+		mts = append(mts, mt)
+	}
+	slices.Sort(mts)
+	var b bytes.Buffer
+	b.WriteString("# Protocol catalogue\n")
+	for _, mt := range mts {
+		m := messages[mt]
+		fmt.Fprintf(&b, "\n## %s (%d messages)\n\n", mt, m.Count)
+		if len(m.Fields) == 0 {
+			continue
+		}
+		b.WriteString("| Field | Name | Kinds | Frequency | Min | Max | Examples |\n")
+		b.WriteString("|---|---|---|---|---|---|---|\n")
+		for _, key := range sortedKeys(m.Fields) {
+			f := m.Fields[key]
+			name := f.Name
+			if name == "" {
+				name = "**unknown**"
+			}
+			examples := make([]string, len(f.Examples))
+			for i, e := range f.Examples {
+				examples[i] = "`" + strings.ReplaceAll(truncate(string(e), 40), "|", "\\|") + "`"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %v | %.1f%% | %s | %s | %s |\n",
+				key, name, f.Kinds, f.Frequency*100, formatBound(f.Min), formatBound(f.Max), strings.Join(examples, ", "))
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Numeric codes in numeric order, anything else after
+func sortedKeys(fields map[string]*FieldStats) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		x, errA := strconv.Atoi(a)
+		y, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return x - y
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return keys
+}
+
+func formatBound(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'g', -1, 64)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
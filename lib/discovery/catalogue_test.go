@@ -0,0 +1,47 @@
+package discovery_test
+
+import (
+	"bursa-alert/lib/discovery"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCatalogue(t *testing.T) {
+	c := discovery.New()
+	c.Observe("MT", []byte(`{"1": 3, "209": 1000}`))
+	c.Observe("MT", []byte(`{"1": 4, "209": 1500, "500": "abc"}`))
+	c.Observe("XX", []byte(`{"7": [1, 2, 3]}`))
+
+	var b bytes.Buffer
+	if err := c.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]discovery.MessageStats
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	mt := out["MT"]
+	if mt.Count != 2 {
+		t.Errorf("expected 2 MT messages, got %d", mt.Count)
+	}
+	price := mt.Fields["209"]
+	if price.Name != "last_price" || *price.Min != 1000 || *price.Max != 1500 || price.Frequency != 1 {
+		t.Errorf("unexpected stats for 209: %+v", price)
+	}
+	if f := mt.Fields["500"]; f.Name != "" || f.Frequency != 0.5 || f.Kinds[0] != "string" {
+		t.Errorf("unexpected stats for 500: %+v", f)
+	}
+	if f := out["XX"].Fields["7"]; *f.Max != 3 || f.Kinds[0] != "list" {
+		t.Errorf("unexpected stats for 7: %+v", f)
+	}
+
+	b.Reset()
+	if err := c.WriteMarkdown(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "| 500 | **unknown** | [string] | 50.0% | 3 | 3 | `\"abc\"` |") {
+		t.Errorf("unexpected markdown:\n%s", b.String())
+	}
+}
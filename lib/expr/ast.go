@@ -0,0 +1,70 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Node interface {
+	Pos() int
+	String() string
+}
+
+type (
+	Number struct {
+		At   int
+		Text string
+	}
+	Duration struct {
+		At   int
+		Text string
+	}
+	Ident struct {
+		At   int
+		Name string
+	}
+	Unary struct {
+		At int
+		Op string
+		X  Node
+	}
+	Binary struct {
+		Op   string
+		X, Y Node
+	}
+	Call struct {
+		At   int
+		Name string
+		Args []Node
+	}
+)
+
+func (n *Number) Pos() int   { return n.At }
+func (n *Duration) Pos() int { return n.At }
+func (n *Ident) Pos() int    { return n.At }
+func (n *Unary) Pos() int    { return n.At }
+func (n *Binary) Pos() int   { return n.X.Pos() }
+func (n *Call) Pos() int     { return n.At }
+
+func (n *Number) String() string   { return n.Text }
+func (n *Duration) String() string { return n.Text }
+func (n *Ident) String() string    { return n.Name }
+
+func (n *Unary) String() string {
+	if n.Op == "not" {
+		return fmt.Sprintf("(not %s)", n.X)
+	}
+	return fmt.Sprintf("(%s%s)", n.Op, n.X)
+}
+
+func (n *Binary) String() string {
+	return fmt.Sprintf("(%s %s %s)", n.X, n.Op, n.Y)
+}
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+}
@@ -0,0 +1,160 @@
+package expr
+
+import (
+	"bursa-alert/lib/models"
+	"fmt"
+	"strconv"
+)
+
+type Type int
+
+const (
+	TypeNumber Type = iota
+	TypeBool
+	// Only written as a literal, like 5m
+	TypeDuration
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeNumber:
+		return "number"
+	case TypeBool:
+		return "bool"
+	case TypeDuration:
+		return "duration"
+	default:
+		return "unknown"
+	}
+}
+
+// Variables and functions an expression can refer to
+type Env struct {
+	Variables map[string]func(id uint) float64
+	// Exact values of the variables that can be prices
+	Prices    map[string]func(id uint) (models.Price, bool)
+	Functions map[string]Function
+}
+
+type Function struct {
+	Params []Type
+	// Trailing parameters that can be left out
+	Optional int
+	// Whether the last parameter repeats
+	Variadic bool
+	Result   Type
+	// Compiles a call from its checked arguments. Errors are reported at
+	// the call
+	Bind func(args []Term) (Term, error)
+}
+
+// Checks that n is well typed in env and returns its type
+func Check(n Node, env *Env) (Type, error) {
+	switch n := n.(type) {
+	case *Number:
+		if _, err := strconv.ParseFloat(n.Text, 64); err != nil {
+			return 0, errorf(n.At, "malformed number %s", n.Text)
+		}
+		return TypeNumber, nil
+	case *Duration:
+		return TypeDuration, nil
+	case *Ident:
+		if _, ok := env.Variables[n.Name]; !ok {
+			if _, ok := env.function(n.Name); ok {
+				return 0, errorf(n.At, "%s is a function", n.Name)
+			}
+			return 0, errorf(n.At, "unknown variable %s", n.Name)
+		}
+		return TypeNumber, nil
+	case *Unary:
+		want := TypeNumber
+		if n.Op == "not" {
+			want = TypeBool
+		}
+		if err := expect(n.X, env, want, n.Op); err != nil {
+			return 0, err
+		}
+		return want, nil
+	case *Binary:
+		return checkBinary(n, env)
+	case *Call:
+		f, ok := env.function(n.Name)
+		if !ok {
+			return 0, errorf(n.At, "unknown function %s", n.Name)
+		}
+		if err := f.arity(n); err != nil {
+			return 0, err
+		}
+		for i, arg := range n.Args {
+			if err := expect(arg, env, f.param(i), n.Name); err != nil {
+				return 0, err
+			}
+		}
+		return f.Result, nil
+	default:
+		return 0, fmt.Errorf("unexpected node %T", n)
+	}
+}
+
+func checkBinary(n *Binary, env *Env) (Type, error) {
+	switch n.Op {
+	case "and", "or":
+		if err := expect(n.X, env, TypeBool, n.Op); err != nil {
+			return 0, err
+		}
+		return TypeBool, expect(n.Y, env, TypeBool, n.Op)
+	case "==", "!=":
+		x, err := Check(n.X, env)
+		if err != nil {
+			return 0, err
+		}
+		if x == TypeDuration {
+			return 0, errorf(n.X.Pos(), "cannot compare durations")
+		}
+		return TypeBool, expect(n.Y, env, x, n.Op)
+	case "<", "<=", ">", ">=":
+		if err := expect(n.X, env, TypeNumber, n.Op); err != nil {
+			return 0, err
+		}
+		return TypeBool, expect(n.Y, env, TypeNumber, n.Op)
+	default:
+		if err := expect(n.X, env, TypeNumber, n.Op); err != nil {
+			return 0, err
+		}
+		return TypeNumber, expect(n.Y, env, TypeNumber, n.Op)
+	}
+}
+
+func expect(n Node, env *Env, want Type, context string) error {
+	t, err := Check(n, env)
+	if err != nil {
+		return err
+	}
+	if t != want {
+		return errorf(n.Pos(), "%s expects a %s, got %s %s", context, want, t, n)
+	}
+	return nil
+}
+
+func (f Function) arity(c *Call) error {
+	required := len(f.Params) - f.Optional
+	switch {
+	case len(c.Args) < required:
+		return errorf(c.At, "%s takes at least %d arguments", c.Name, required)
+	case len(c.Args) > len(f.Params) && !f.Variadic:
+		return errorf(c.At, "%s takes at most %d arguments", c.Name, len(f.Params))
+	}
+	return nil
+}
+
+func (f Function) param(i int) Type {
+	return f.Params[min(i, len(f.Params)-1)]
+}
+
+func (env *Env) function(name string) (Function, bool) {
+	if f, ok := env.Functions[name]; ok {
+		return f, true
+	}
+	f, ok := builtins[name]
+	return f, ok
+}
@@ -0,0 +1,312 @@
+package expr
+
+import (
+	"bursa-alert/lib/models"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A compiled expression. Num or Bool is set as Type says. Literals keep their
+// source so functions can take them as constant parameters
+type Term struct {
+	Type Type
+	Num  func(id uint) float64
+	// Set alongside Num for numbers that can be prices, which compare, add
+	// and subtract exactly when both sides are. Reports false when the value
+	// is not an exact price
+	Price   func(id uint) (models.Price, bool)
+	Bool    func(id uint) bool
+	Literal string
+}
+
+func (t Term) IsLiteral() bool {
+	return t.Literal != ""
+}
+
+// Functions available in every environment
+var builtins = map[string]Function{
+	"abs": {Params: []Type{TypeNumber}, Result: TypeNumber, Bind: func(args []Term) (Term, error) {
+		x := args[0].Num
+		return number(func(id uint) float64 { return math.Abs(x(id)) }), nil
+	}},
+	"min": {Params: []Type{TypeNumber, TypeNumber}, Variadic: true, Result: TypeNumber, Bind: func(args []Term) (Term, error) {
+		return fold(args, math.Min), nil
+	}},
+	"max": {Params: []Type{TypeNumber, TypeNumber}, Variadic: true, Result: TypeNumber, Bind: func(args []Term) (Term, error) {
+		return fold(args, math.Max), nil
+	}},
+}
+
+func number(f func(id uint) float64) Term {
+	return Term{Type: TypeNumber, Num: f}
+}
+
+func boolean(f func(id uint) bool) Term {
+	return Term{Type: TypeBool, Bool: f}
+}
+
+func fold(args []Term, f func(a, b float64) float64) Term {
+	return number(func(id uint) float64 {
+		acc := args[0].Num(id)
+		for _, arg := range args[1:] {
+			acc = f(acc, arg.Num(id))
+		}
+		return acc
+	})
+}
+
+// A checked and compiled boolean expression
+type Program struct {
+	Source string
+	Root   Node
+	env    *Env
+	eval   func(id uint) bool
+}
+
+// Parses, checks and compiles src, which must be a condition
+func Compile(src string, env *Env) (*Program, error) {
+	root, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Check(root, env)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeBool {
+		return nil, errorf(0, "expression is a %s, not a condition", t)
+	}
+	term, err := (&compiler{env: env}).compile(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{Source: src, Root: root, env: env, eval: term.Bool}, nil
+}
+
+func (p *Program) Eval(id uint) bool {
+	return p.eval(id)
+}
+
+// The program with every numeric comparison loosened by band, a fraction of
+// its right hand side, so x > y holds once x > y - band * |y|. Comparisons
+// under not are tightened instead, so the result holds whenever the program
+// would hold for values within the band
+func (p *Program) Loosen(band float64) *Program {
+	term, err := (&compiler{env: p.env, band: band}).compile(p.Root)
+	if err != nil {
+		// Binding already succeeded once
+		panic(err)
+	}
+	return &Program{Source: p.Source, Root: p.Root, env: p.env, eval: term.Bool}
+}
+
+type compiler struct {
+	env *Env
+	// Loosens comparisons when positive and tightens them when negative
+	band float64
+}
+
+// Compiles a checked node
+func (c *compiler) compile(n Node) (Term, error) {
+	env := c.env
+	switch n := n.(type) {
+	case *Number:
+		f, _ := strconv.ParseFloat(n.Text, 64)
+		t := number(func(uint) float64 { return f })
+		if p, err := models.ParsePrice(n.Text); err == nil {
+			t.Price = func(uint) (models.Price, bool) { return p, true }
+		}
+		t.Literal = n.Text
+		return t, nil
+	case *Duration:
+		return Term{Type: TypeDuration, Literal: n.Text}, nil
+	case *Ident:
+		t := number(env.Variables[n.Name])
+		t.Price = env.Prices[n.Name]
+		return t, nil
+	case *Unary:
+		x, err := c.negated(n.Op == "not").compile(n.X)
+		if err != nil {
+			return Term{}, err
+		}
+		if n.Op == "not" {
+			return boolean(func(id uint) bool { return !x.Bool(id) }), nil
+		}
+		t := number(func(id uint) float64 { return -x.Num(id) })
+		if x.Price != nil {
+			t.Price = func(id uint) (models.Price, bool) {
+				p, ok := x.Price(id)
+				return -p, ok
+			}
+		}
+		return t, nil
+	case *Binary:
+		// Either side of a comparison of conditions can pull both ways
+		inner := c
+		if n.Op == "==" || n.Op == "!=" {
+			inner = &compiler{env: env}
+		}
+		x, err := inner.compile(n.X)
+		if err != nil {
+			return Term{}, err
+		}
+		y, err := inner.compile(n.Y)
+		if err != nil {
+			return Term{}, err
+		}
+		return binary(n.Op, x, y, c.band), nil
+	case *Call:
+		f, _ := env.function(n.Name)
+		args := make([]Term, len(n.Args))
+		for i, arg := range n.Args {
+			var err error
+			if args[i], err = (&compiler{env: env}).compile(arg); err != nil {
+				return Term{}, err
+			}
+		}
+		t, err := f.Bind(args)
+		if err != nil {
+			return Term{}, errorf(n.At, "%s: %s", n.Name, err)
+		}
+		return t, nil
+	default:
+		return Term{}, fmt.Errorf("unexpected node %T", n)
+	}
+}
+
+func (c *compiler) negated(not bool) *compiler {
+	if !not || c.band == 0 {
+		return c
+	}
+	return &compiler{env: c.env, band: -c.band}
+}
+
+func binary(op string, x, y Term, band float64) Term {
+	a, b := x.Num, y.Num
+	if band != 0 && x.Type == TypeNumber {
+		if t, ok := loosened(op, a, b, band); ok {
+			return t
+		}
+	}
+	if x.Price != nil && y.Price != nil {
+		if t, ok := exact(op, x, y); ok {
+			return t
+		}
+	}
+	switch op {
+	case "and":
+		return boolean(func(id uint) bool { return x.Bool(id) && y.Bool(id) })
+	case "or":
+		return boolean(func(id uint) bool { return x.Bool(id) || y.Bool(id) })
+	case "==":
+		if x.Type == TypeBool {
+			return boolean(func(id uint) bool { return x.Bool(id) == y.Bool(id) })
+		}
+		return boolean(func(id uint) bool { return a(id) == b(id) })
+	case "!=":
+		if x.Type == TypeBool {
+			return boolean(func(id uint) bool { return x.Bool(id) != y.Bool(id) })
+		}
+		// Like every other comparison, false when either side is NaN
+		return boolean(func(id uint) bool {
+			x, y := a(id), b(id)
+			return x != y && !math.IsNaN(x) && !math.IsNaN(y)
+		})
+	case "<":
+		return boolean(func(id uint) bool { return a(id) < b(id) })
+	case "<=":
+		return boolean(func(id uint) bool { return a(id) <= b(id) })
+	case ">":
+		return boolean(func(id uint) bool { return a(id) > b(id) })
+	case ">=":
+		return boolean(func(id uint) bool { return a(id) >= b(id) })
+	case "+":
+		return number(func(id uint) float64 { return a(id) + b(id) })
+	case "-":
+		return number(func(id uint) float64 { return a(id) - b(id) })
+	case "*":
+		return number(func(id uint) float64 { return a(id) * b(id) })
+	case "/":
+		return number(func(id uint) float64 { return a(id) / b(id) })
+	case "%":
+		return number(func(id uint) float64 { return math.Mod(a(id), b(id)) })
+	default:
+		panic("invalid operator")
+	}
+}
+
+// Compares, adds or subtracts prices as prices when both sides are, and as
+// floats otherwise
+func exact(op string, x, y Term) (Term, bool) {
+	switch op {
+	case "+", "-":
+		t := binary(op, Term{Type: TypeNumber, Num: x.Num}, Term{Type: TypeNumber, Num: y.Num}, 0)
+		t.Price = func(id uint) (models.Price, bool) {
+			p, okp := x.Price(id)
+			q, okq := y.Price(id)
+			if op == "-" {
+				q = -q
+			}
+			return p + q, okp && okq
+		}
+		return t, true
+	case "==", "!=", "<", "<=", ">", ">=":
+		float := binary(op, Term{Type: TypeNumber, Num: x.Num}, Term{Type: TypeNumber, Num: y.Num}, 0).Bool
+		return boolean(func(id uint) bool {
+			p, okp := x.Price(id)
+			q, okq := y.Price(id)
+			if !okp || !okq {
+				return float(id)
+			}
+			switch op {
+			case "==":
+				return p == q
+			case "!=":
+				return p != q
+			case "<":
+				return p < q
+			case "<=":
+				return p <= q
+			case ">":
+				return p > q
+			default:
+				return p >= q
+			}
+		}), true
+	}
+	return Term{}, false
+}
+
+func loosened(op string, a, b func(uint) float64, band float64) (Term, bool) {
+	slack := func(y float64) float64 {
+		return band * math.Abs(y)
+	}
+	switch op {
+	case "==":
+		if band < 0 {
+			return Term{}, false
+		}
+		return boolean(func(id uint) bool {
+			y := b(id)
+			return math.Abs(a(id)-y) <= slack(y)
+		}), true
+	case "!=":
+		if band > 0 {
+			return Term{}, false
+		}
+		return boolean(func(id uint) bool {
+			x, y := a(id), b(id)
+			return !(math.Abs(x-y) <= -slack(y)) && !math.IsNaN(x) && !math.IsNaN(y)
+		}), true
+	case "<":
+		return boolean(func(id uint) bool { y := b(id); return a(id) < y+slack(y) }), true
+	case "<=":
+		return boolean(func(id uint) bool { y := b(id); return a(id) <= y+slack(y) }), true
+	case ">":
+		return boolean(func(id uint) bool { y := b(id); return a(id) > y-slack(y) }), true
+	case ">=":
+		return boolean(func(id uint) bool { y := b(id); return a(id) >= y-slack(y) }), true
+	}
+	return Term{}, false
+}
@@ -0,0 +1,152 @@
+package expr_test
+
+import (
+	"bursa-alert/lib/expr"
+	"bursa-alert/lib/models"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var env = &expr.Env{
+	Variables: map[string]func(uint) float64{
+		"last_price":     func(uint) float64 { return 1.10 },
+		"preclose_price": func(uint) float64 { return 1.00 },
+		"buy_rate":       func(uint) float64 { return 0.5 },
+		"trade_value":    func(uint) float64 { return 600000 },
+		"missing":        func(uint) float64 { return math.NaN() },
+	},
+	Functions: map[string]expr.Function{
+		// Doubles its argument, which must be a literal
+		"twice": {Params: []expr.Type{expr.TypeNumber, expr.TypeDuration}, Optional: 1, Result: expr.TypeNumber, Bind: func(args []expr.Term) (expr.Term, error) {
+			if !args[0].IsLiteral() {
+				return expr.Term{}, errors.New("needs a constant")
+			}
+			f, _ := strconv.ParseFloat(args[0].Literal, 64)
+			return expr.Term{Type: expr.TypeNumber, Num: func(uint) float64 { return 2 * f }}, nil
+		}},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for src, want := range map[string]string{
+		"1 + 2 * 3":               "(1 + (2 * 3))",
+		"-a * b":                  "((-a) * b)",
+		"not a > b and c < d":     "((not (a > b)) and (c < d))",
+		"a or b && !c":            "(a or (b and (not c)))",
+		"(a + b) / 2 >= f(1, 5m)": "(((a + b) / 2) >= f(1, 5m))",
+		"a - b - c":               "((a - b) - c)",
+		"x AND y Or z":            "((x and y) or z)",
+		"f()":                     "f()",
+		".5 < a":                  "(.5 < a)",
+	} {
+		n, err := expr.Parse(src)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if n.String() != want {
+			t.Errorf("%s parsed as %s, expected %s", src, n, want)
+		}
+	}
+	for _, src := range []string{"", "a +", "(a", "a b", "1..2", "a < b < c", "f(a,", "a $ b", "1e5 > a", "a > 2E-3"} {
+		if _, err := expr.Parse(src); err == nil {
+			t.Errorf("%q parsed", src)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	for src, want := range map[string]bool{
+		"last_price > 1.05 * preclose_price and (buy_rate >= 0.6 or trade_value > 500000)": true,
+		"last_price == 1.10":                     true,
+		"abs(preclose_price - last_price) < 0.2": true,
+		"max(buy_rate, 0.2, 0.7) == 0.7":         true,
+		"min(last_price, preclose_price) == 1":   true,
+		"twice(3) == 6 and twice(1, 5m) == 2":    true,
+		"not (buy_rate > 0.4)":                   false,
+		"(last_price > 1) == (buy_rate > 1)":     false,
+		"missing > 0 or missing <= 0":            false,
+		"missing != 0":                           false,
+		"missing != missing":                     false,
+		"trade_value % 7 == 600000 - 7 * 85714":  true,
+	} {
+		p, err := expr.Compile(src, env)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if got := p.Eval(0); got != want {
+			t.Errorf("%s evaluated to %t", src, got)
+		}
+	}
+}
+
+func TestExactPrices(t *testing.T) {
+	prices := &expr.Env{
+		Variables: map[string]func(uint) float64{
+			"last_price":     func(uint) float64 { return 1.015 },
+			"preclose_price": func(uint) float64 { return 1.01 },
+			"missing":        func(uint) float64 { return math.NaN() },
+		},
+		Prices: map[string]func(uint) (models.Price, bool){
+			"last_price":     func(uint) (models.Price, bool) { return 1015, true },
+			"preclose_price": func(uint) (models.Price, bool) { return 1010, true },
+			"missing":        func(uint) (models.Price, bool) { return 0, false },
+		},
+	}
+	// As floats, 1.015 - 1.01 is just under 0.005
+	for src, want := range map[string]bool{
+		"last_price - preclose_price == 0.005":  true,
+		"last_price - preclose_price >= 0.005":  true,
+		"-preclose_price + last_price == 0.005": true,
+		"last_price == 1.015":                   true,
+		"last_price != 1.015":                   false,
+		"missing + last_price > 0":              false,
+		"missing != 1.015":                      false,
+	} {
+		p, err := expr.Compile(src, prices)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		if got := p.Eval(0); got != want {
+			t.Errorf("%s evaluated to %t", src, got)
+		}
+	}
+}
+
+func TestLoosenMissing(t *testing.T) {
+	p, err := expr.Compile("missing != 0", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, band := range []float64{0.1, -0.1} {
+		if p.Loosen(band).Eval(0) {
+			t.Errorf("missing != 0 loosened by %g held", band)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, src := range []string{
+		"last_price",
+		"last_price + (buy_rate > 1) > 0",
+		"unknown > 1",
+		"twice > 1",
+		"last_price(5m) > 1",
+		"twice(last_price) > 1",
+		"twice(1, 2) > 1",
+		"twice() > 1",
+		"abs(1, 2) > 1",
+		"5m == 5m",
+		"not last_price",
+	} {
+		_, err := expr.Compile(src, env)
+		var e *expr.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s compiled: %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// A small expression language for alert conditions, such as
+// last_price > 1.05 * preclose_price and (buy_rate >= 0.6 or rsi(14, 5m) < 30)
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	// A number with a unit, like 5m
+	TokenDuration
+	TokenIdent
+	TokenOp
+	TokenLParen
+	TokenRParen
+	TokenComma
+)
+
+type Token struct {
+	Kind TokenKind
+	Text string
+	// Byte offset in the source
+	Pos int
+}
+
+// Words that read as operators
+var keywords = map[string]string{"and": "and", "or": "or", "not": "not"}
+
+// Symbol spellings of the boolean operators
+var aliases = map[string]string{"&&": "and", "||": "or", "!": "not"}
+
+// A syntax or type error at a position in the source
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, a ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Splits src into tokens, ending with TokenEOF
+func Lex(src string) ([]Token, error) {
+	var tokens []Token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(rune(src[i+1]))):
+			for i < len(src) && (isDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			kind := TokenNumber
+			if i < len(src) && isLetter(rune(src[i])) {
+				unit := i
+				for i < len(src) && isLetter(rune(src[i])) {
+					i++
+				}
+				// Exponents like 1e5 or 2E-3 are not supported
+				if strings.EqualFold(src[unit:i], "e") || (i < len(src) && (isDigit(rune(src[i])) || src[i] == '.')) {
+					return nil, errorf(start, "malformed number %s", src[start:i])
+				}
+				kind = TokenDuration
+			}
+			if strings.Count(src[start:i], ".") > 1 {
+				return nil, errorf(start, "malformed number %s", src[start:i])
+			}
+			tokens = append(tokens, Token{kind, src[start:i], start})
+			continue
+		case isLetter(c):
+			for i < len(src) && (isLetter(rune(src[i])) || isDigit(rune(src[i]))) {
+				i++
+			}
+			word := src[start:i]
+			if op, ok := keywords[strings.ToLower(word)]; ok {
+				tokens = append(tokens, Token{TokenOp, op, start})
+			} else {
+				tokens = append(tokens, Token{TokenIdent, word, start})
+			}
+			continue
+		case c == '(':
+			tokens = append(tokens, Token{TokenLParen, "(", start})
+		case c == ')':
+			tokens = append(tokens, Token{TokenRParen, ")", start})
+		case c == ',':
+			tokens = append(tokens, Token{TokenComma, ",", start})
+		default:
+			op := operator(src[i:])
+			if op == "" {
+				return nil, errorf(start, "unexpected %q", c)
+			}
+			i += len(op)
+			if alias, ok := aliases[op]; ok {
+				op = alias
+			}
+			tokens = append(tokens, Token{TokenOp, op, start})
+			continue
+		}
+		i++
+	}
+	return append(tokens, Token{TokenEOF, "", len(src)}), nil
+}
+
+// Longest operator at the start of s
+func operator(s string) string {
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	if strings.ContainsAny(s[:1], "+-*/%<>!") {
+		return s[:1]
+	}
+	return ""
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
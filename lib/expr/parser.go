@@ -0,0 +1,145 @@
+package expr
+
+// Binding power of binary operators, loosest first
+var precedence = map[string]int{
+	"or":  1,
+	"and": 2,
+	"==":  4, "!=": 4, "<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+const (
+	// Operand of not, so not a > b negates the comparison
+	precNot = 3
+	// Operand of unary minus
+	precUnary = 7
+)
+
+type parser struct {
+	tokens []Token
+	i      int
+}
+
+// Parses src into its syntax tree
+func Parse(src string) (Node, error) {
+	tokens, err := Lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.Kind != TokenEOF {
+		return nil, errorf(t.Pos, "unexpected %q", t.Text)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() Token {
+	t := p.tokens[p.i]
+	if t.Kind != TokenEOF {
+		p.i++
+	}
+	return t
+}
+
+// Parses operators binding at least as tightly as min
+func (p *parser) expr(min int) (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.Text]
+		if t.Kind != TokenOp || !ok || prec < min {
+			return x, nil
+		}
+		p.next()
+		y, err := p.expr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Op: t.Text, X: x, Y: y}
+		// Comparisons do not chain
+		if next := p.peek(); prec == 4 && precedence[next.Text] == 4 && next.Kind == TokenOp {
+			return nil, errorf(next.Pos, "comparisons cannot be chained, use and")
+		}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	t := p.peek()
+	if t.Kind == TokenOp && (t.Text == "not" || t.Text == "-") {
+		p.next()
+		prec := precUnary
+		if t.Text == "not" {
+			prec = precNot
+		}
+		x, err := p.expr(prec)
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{At: t.Pos, Op: t.Text, X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	switch t.Kind {
+	case TokenNumber:
+		return &Number{At: t.Pos, Text: t.Text}, nil
+	case TokenDuration:
+		return &Duration{At: t.Pos, Text: t.Text}, nil
+	case TokenIdent:
+		if p.peek().Kind != TokenLParen {
+			return &Ident{At: t.Pos, Name: t.Text}, nil
+		}
+		p.next()
+		return p.call(t)
+	case TokenLParen:
+		x, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Kind != TokenRParen {
+			return nil, errorf(closing.Pos, "expected )")
+		}
+		return x, nil
+	case TokenEOF:
+		return nil, errorf(t.Pos, "unexpected end of expression")
+	default:
+		return nil, errorf(t.Pos, "unexpected %q", t.Text)
+	}
+}
+
+// Parses the arguments of a call after its opening parenthesis
+func (p *parser) call(name Token) (Node, error) {
+	c := &Call{At: name.Pos, Name: name.Text}
+	if p.peek().Kind == TokenRParen {
+		p.next()
+		return c, nil
+	}
+	for {
+		arg, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, arg)
+		switch t := p.next(); t.Kind {
+		case TokenComma:
+		case TokenRParen:
+			return c, nil
+		default:
+			return nil, errorf(t.Pos, "expected , or ) in call to %s", c.Name)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package global
+
+import (
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/session"
+)
+
+// A trading day of 1m bars plus some slack
+const DefaultMaxBars = 1000
+
+var Candles = candles.NewBuilder(session.Default, DefaultMaxBars)
@@ -0,0 +1,176 @@
+// Process wide stores shared between the data stream and alert evaluation
+package global
+
+import (
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// A full trading day plus some slack. Rule windows are checked against it
+	DefaultMaxAge = 12 * time.Hour
+	// Only a safety bound on memory, well above a day of updates for the
+	// busiest counter, so that windows up to DefaultMaxAge are kept in full
+	DefaultMaxLen = 1 << 17
+)
+
+var Entries = NewHistory(DefaultMaxAge, DefaultMaxLen)
+
+// A stock entry along with the time it was received
+type Record struct {
+	Entry    models.StockEntry
+	Received time.Time
+}
+
+// Per stock time series of entries, ordered by receive time
+type History struct {
+	mu      sync.RWMutex
+	records map[uint][]Record
+	maxAge  time.Duration
+	maxLen  int
+	now     func() time.Time
+}
+
+// maxAge and maxLen bound the retention per stock. Zero disables the bound
+func NewHistory(maxAge time.Duration, maxLen int) *History {
+	return &History{
+		records: make(map[uint][]Record),
+		maxAge:  maxAge,
+		maxLen:  maxLen,
+		now:     time.Now,
+	}
+}
+
+// Replaces the clock used for receive times and window lookups
+func (h *History) SetClock(now func() time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.now = now
+}
+
+func (h *History) Now() time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.now()
+}
+
+func (h *History) Push(s models.StockEntry) models.StockEntry {
+	return h.PushAt(s, h.Now())
+}
+
+// Records s as received at t, tagged with the market phase at t. Out of
+// order entries are inserted in place
+func (h *History) PushAt(s models.StockEntry, t time.Time) models.StockEntry {
+	s = s.WithPhase(session.Default.Phase(t))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := s.GetIndex()
+	records := h.records[id]
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Received.After(t)
+	})
+	records = append(records, Record{})
+	copy(records[i+1:], records[i:])
+	records[i] = Record{Entry: s, Received: t}
+	h.records[id] = h.prune(records)
+	return s
+}
+
+func (h *History) prune(records []Record) []Record {
+	start := 0
+	if h.maxLen > 0 && len(records) > h.maxLen {
+		start = len(records) - h.maxLen
+	}
+	if h.maxAge > 0 && len(records) > 0 {
+		cutoff := records[len(records)-1].Received.Add(-h.maxAge)
+		for start < len(records)-1 && records[start].Received.Before(cutoff) {
+			start++
+		}
+	}
+	if start == 0 {
+		return records
+	}
+	// Copy so the dropped records can be collected
+	return append(make([]Record, 0, len(records)-start), records[start:]...)
+}
+
+// Latest entry for the stock
+func (h *History) FetchOne(id uint) *models.StockEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	records := h.records[id]
+	if len(records) == 0 {
+		return nil
+	}
+	e := records[len(records)-1].Entry
+	return &e
+}
+
+// Oldest entry received within d of now
+func (h *History) FetchOldestWithin(id uint, d time.Duration) *models.StockEntry {
+	now := h.Now()
+	records := h.Within(id, now.Add(-d), now)
+	if len(records) == 0 {
+		return nil
+	}
+	return &records[0].Entry
+}
+
+// Records received in the inclusive window [from, to], oldest first
+func (h *History) Within(id uint, from, to time.Time) []Record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	records := h.records[id]
+	i := sort.Search(len(records), func(i int) bool {
+		return !records[i].Received.Before(from)
+	})
+	j := sort.Search(len(records), func(i int) bool {
+		return records[i].Received.After(to)
+	})
+	if i >= j {
+		return nil
+	}
+	return append([]Record(nil), records[i:j]...)
+}
+
+// Records received after from, preceded by up to n received at or before
+// it, oldest first
+func (h *History) After(id uint, from time.Time, n int) []Record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	records := h.records[id]
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Received.After(from)
+	})
+	return append([]Record(nil), records[max(i-n, 0):]...)
+}
+
+// Latest entry received at or before t
+func (h *History) At(id uint, t time.Time) *Record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	records := h.records[id]
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Received.After(t)
+	})
+	if i == 0 {
+		return nil
+	}
+	r := records[i-1]
+	return &r
+}
+
+func (h *History) Len(id uint) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.records[id])
+}
+
+func (h *History) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = make(map[uint][]Record)
+}
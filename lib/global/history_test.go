@@ -0,0 +1,111 @@
+package global_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"testing"
+	"time"
+)
+
+func entry(id, price uint) models.StockEntry {
+	return models.NewStockEntry(internal.StockEntry{StockIndex: id, LastPrice: price})
+}
+
+func TestWindow(t *testing.T) {
+	h := global.NewHistory(0, 0)
+	base := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	now := base
+	h.SetClock(func() time.Time { return now })
+	for i := uint(0); i < 10; i++ {
+		h.PushAt(entry(1, 1000+i), base.Add(time.Duration(i)*time.Minute))
+	}
+	now = base.Add(9 * time.Minute)
+
+	if e := h.FetchOne(1); e == nil || e.GetLastPrice() != 1009 {
+		t.Errorf("FetchOne returned %v", e)
+	}
+	if e := h.FetchOne(2); e != nil {
+		t.Errorf("FetchOne on unknown stock returned %v", e)
+	}
+	// Window bounds are inclusive
+	if e := h.FetchOldestWithin(1, 3*time.Minute); e == nil || e.GetLastPrice() != 1006 {
+		t.Errorf("FetchOldestWithin(3m) returned %v", e)
+	}
+	if e := h.FetchOldestWithin(1, time.Hour); e == nil || e.GetLastPrice() != 1000 {
+		t.Errorf("FetchOldestWithin(1h) returned %v", e)
+	}
+	now = base.Add(time.Hour)
+	if e := h.FetchOldestWithin(1, time.Minute); e != nil {
+		t.Errorf("FetchOldestWithin on stale stock returned %v", e)
+	}
+
+	records := h.Within(1, base.Add(2*time.Minute), base.Add(4*time.Minute))
+	if len(records) != 3 {
+		t.Fatalf("Within returned %d records", len(records))
+	}
+	if !records[0].Received.Equal(base.Add(2 * time.Minute)) {
+		t.Errorf("Within is not ordered oldest first")
+	}
+	if r := h.At(1, base.Add(90*time.Second)); r == nil || r.Entry.GetLastPrice() != 1001 {
+		t.Errorf("At returned %v", r)
+	}
+	if r := h.At(1, base.Add(-time.Second)); r != nil {
+		t.Errorf("At before the first record returned %v", r)
+	}
+	if records := h.After(1, base.Add(7*time.Minute), 2); len(records) != 4 || records[0].Entry.GetLastPrice() != 1006 {
+		t.Errorf("After returned %v", records)
+	}
+	if records := h.After(1, base.Add(-time.Minute), 2); len(records) != 10 {
+		t.Errorf("After before the first record returned %d records", len(records))
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	h := global.NewHistory(0, 0)
+	base := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	h.PushAt(entry(1, 1000), base)
+	h.PushAt(entry(1, 1002), base.Add(2*time.Minute))
+	h.PushAt(entry(1, 1001), base.Add(time.Minute))
+	records := h.Within(1, base, base.Add(time.Hour))
+	for i, r := range records {
+		if r.Entry.GetLastPrice() != models.Price(1000+i) {
+			t.Errorf("record %d has price %s", i, r.Entry.GetLastPrice())
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	base := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	h := global.NewHistory(0, 5)
+	for i := uint(0); i < 20; i++ {
+		h.PushAt(entry(1, i), base.Add(time.Duration(i)*time.Second))
+	}
+	if n := h.Len(1); n != 5 {
+		t.Errorf("expected 5 records, got %d", n)
+	}
+
+	h = global.NewHistory(time.Minute, 0)
+	for i := uint(0); i < 20; i++ {
+		h.PushAt(entry(1, i), base.Add(time.Duration(i)*10*time.Second))
+	}
+	// Records from 130s to 190s
+	if n := h.Len(1); n != 7 {
+		t.Errorf("expected 7 records, got %d", n)
+	}
+	// The latest record is always kept
+	h.PushAt(entry(2, 1), base)
+	if h.FetchOne(2) == nil {
+		t.Errorf("latest record was pruned")
+	}
+
+	// A busy counter keeps every record of a long window
+	h = global.NewHistory(global.DefaultMaxAge, global.DefaultMaxLen)
+	h.SetClock(func() time.Time { return base.Add(3 * time.Hour) })
+	for i := 0; i < 3*3600*2; i++ {
+		h.PushAt(entry(1, uint(i)), base.Add(time.Duration(i)*time.Second/2))
+	}
+	if e := h.FetchOldestWithin(1, time.Hour); e == nil || e.GetLastPrice() != models.Price(2*2*3600) {
+		t.Errorf("the hour window starts at %v", e)
+	}
+}
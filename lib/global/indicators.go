@@ -0,0 +1,5 @@
+package global
+
+import "bursa-alert/lib/indicators"
+
+var Indicators = indicators.NewEngine(Candles)
@@ -0,0 +1,80 @@
+package global
+
+import (
+	"bursa-alert/lib/models"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+var Metadata = NewMetadataStore()
+
+// Names and tickers of every listed stock, keyed by stock id
+type MetadataStore struct {
+	mu      sync.RWMutex
+	stocks  map[uint]models.StockMetadata
+	updated time.Time
+}
+
+func NewMetadataStore() *MetadataStore {
+	return &MetadataStore{stocks: make(map[uint]models.StockMetadata)}
+}
+
+func (s *MetadataStore) Get(id uint) (models.StockMetadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.stocks[id]
+	return m, ok
+}
+
+// A copy of every stock's metadata
+func (s *MetadataStore) All() map[uint]models.StockMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.stocks)
+}
+
+// Every stock id, sorted
+func (s *MetadataStore) Ids() []uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]uint, 0, len(s.stocks))
+	for id := range s.stocks {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// When the metadata was last loaded or updated
+func (s *MetadataStore) Updated() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// Replaces the metadata without reporting changes
+func (s *MetadataStore) Load(m map[uint]models.StockMetadata, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stocks = maps.Clone(m)
+	s.updated = at
+}
+
+// Replaces the metadata and returns what changed
+func (s *MetadataStore) Update(m map[uint]models.StockMetadata, at time.Time) []models.MetadataEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := models.DiffMetadata(s.stocks, m, at)
+	s.stocks = maps.Clone(m)
+	s.updated = at
+	return events
+}
+
+func (s *MetadataStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stocks = make(map[uint]models.StockMetadata)
+	s.updated = time.Time{}
+}
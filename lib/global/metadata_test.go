@@ -0,0 +1,27 @@
+package global_test
+
+import (
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"testing"
+	"time"
+)
+
+func TestMetadataUpdate(t *testing.T) {
+	s := global.NewMetadataStore()
+	at := time.Now()
+	s.Load(map[uint]models.StockMetadata{1: {Name: "A", Ticker: "0001", Id: 1}}, at)
+	events := s.Update(map[uint]models.StockMetadata{
+		1: {Name: "A", Ticker: "0001", Id: 1},
+		2: {Name: "B", Ticker: "0002", Id: 2},
+	}, at)
+	if len(events) != 1 || events[0].Kind != models.EventListing || events[0].Id != 2 || events[0].Stock().Name != "B" {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if events := s.Update(s.All(), at); len(events) != 0 {
+		t.Errorf("unchanged metadata reported %+v", events)
+	}
+	if m, ok := s.Get(2); !ok || m.Ticker != "0002" || !s.Updated().Equal(at) {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+}
@@ -0,0 +1,57 @@
+package global
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/models"
+	"errors"
+	"sync"
+)
+
+var Snapshots = NewSnapshots()
+
+// Last known full state of each stock, built up from partial MT/SM frames
+type SnapshotStore struct {
+	mu      sync.Mutex
+	entries map[uint]internal.StockEntry
+}
+
+func NewSnapshots() *SnapshotStore {
+	return &SnapshotStore{entries: make(map[uint]internal.StockEntry)}
+}
+
+// Merges a raw MT/SM data object into the stock's snapshot and returns the result
+func (s *SnapshotStore) Merge(mt string, b []byte) (models.StockEntry, error) {
+	fields, err := internal.DecodeFields(mt, b)
+	if err != nil {
+		return models.StockEntry{}, err
+	}
+	if _, ok := fields[internal.FieldStockIndex]; !ok {
+		return models.StockEntry{}, errors.New("no stock index found")
+	}
+	var id uint
+	if err := fields.Get(internal.FieldStockIndex, &id); err != nil {
+		return models.StockEntry{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[id]
+	if err := entry.Merge(fields); err != nil {
+		return models.StockEntry{}, err
+	}
+	s.entries[id] = entry
+	return models.NewStockEntry(entry), nil
+}
+
+// Current snapshot of the stock, if any frame has been seen for it
+func (s *SnapshotStore) Get(id uint) (models.StockEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	return models.NewStockEntry(entry), ok
+}
+
+func (s *SnapshotStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[uint]internal.StockEntry)
+}
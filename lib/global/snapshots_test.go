@@ -0,0 +1,40 @@
+package global_test
+
+import (
+	"bursa-alert/lib/global"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	s := global.NewSnapshots()
+	frames := []string{
+		`{"1": 7, "209": 1250, "153": 1200}`,
+		`{"1": 7, "114": 300, "117": 100}`,
+		`{"1": 7, "209": 1260}`,
+	}
+	for _, f := range frames {
+		if _, err := s.Merge("MT", []byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, ok := s.Get(7)
+	if !ok {
+		t.Fatal("snapshot missing")
+	}
+	if e.GetLastPrice() != 1260 || e.GetPreclosePrice() != 1200 {
+		t.Errorf("MT fields not merged: %v", e.ToMap())
+	}
+	if e.GetBuyRate() != 0.75 {
+		t.Errorf("SM fields lost after MT frame: %v", e.ToMap())
+	}
+
+	// Fields explicitly sent as zero must be applied
+	e, _ = s.Merge("SM", []byte(`{"1": 7, "117": 0}`))
+	if e.GetSellVolume() != 0 || e.GetBuyRate() != 1 {
+		t.Errorf("zero value not applied: %v", e.ToMap())
+	}
+
+	if _, err := s.Merge("MT", []byte(`{"209": 1}`)); err == nil {
+		t.Error("frame without stock index merged")
+	}
+}
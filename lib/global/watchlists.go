@@ -0,0 +1,57 @@
+package global
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+var Watchlists = NewWatchlistStore()
+
+// Named lists of stocks, each written as a ticker or name
+type WatchlistStore struct {
+	mu    sync.RWMutex
+	lists map[string][]string
+}
+
+func NewWatchlistStore() *WatchlistStore {
+	return &WatchlistStore{lists: make(map[string][]string)}
+}
+
+func (s *WatchlistStore) Get(name string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.lists[name]
+	return l, ok
+}
+
+// A copy of every watchlist
+func (s *WatchlistStore) All() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.lists)
+}
+
+func (s *WatchlistStore) Set(name string, stocks []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists[name] = slices.Clone(stocks)
+}
+
+func (s *WatchlistStore) Delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.lists[name]
+	delete(s.lists, name)
+	return ok
+}
+
+// Replaces every watchlist
+func (s *WatchlistStore) Load(lists map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists = maps.Clone(lists)
+	if s.lists == nil {
+		s.lists = make(map[string][]string)
+	}
+}
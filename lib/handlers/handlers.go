@@ -2,23 +2,49 @@
 package handlers
 
 import (
+	"bursa-alert/internal"
 	"bursa-alert/internal/ws"
 	"bursa-alert/lib/models"
-	"encoding/json"
+	"errors"
+	"sync"
 )
 
 type slData struct {
-	Names   []string `json:"77"`
-	Tickers []string `json:"2"`
-	Offset  uint     `json:"4"`
+	Names   []string
+	Tickers []string
+	Offset  uint
 }
 
-func GetStockMapping(si map[uint]models.StockMetadata) ws.MessageHandler {
+func decodeSL(b []byte) (slData, error) {
+	var sl slData
+	fields, err := internal.DecodeFields("SL", b)
+	if err != nil {
+		return sl, err
+	}
+	if err := fields.Get(internal.FieldNames, &sl.Names); err != nil {
+		return sl, err
+	}
+	if err := fields.Get(internal.FieldTickers, &sl.Tickers); err != nil {
+		return sl, err
+	}
+	if err := fields.Get(internal.FieldOffset, &sl.Offset); err != nil {
+		return sl, err
+	}
+	if len(sl.Names) != len(sl.Tickers) {
+		return sl, errors.New("names and tickers do not match")
+	}
+	return sl, nil
+}
+
+// Fills si from SL pages, holding mu while writing to it
+func GetStockMapping(si map[uint]models.StockMetadata, mu *sync.Mutex) ws.MessageHandler {
 	return func(_ *ws.Connection, b []byte) error {
-		var sl slData
-		if err := json.Unmarshal(b, &sl); err != nil {
+		sl, err := decodeSL(b)
+		if err != nil {
 			return err
 		}
+		mu.Lock()
+		defer mu.Unlock()
 		for i, ticker := range sl.Tickers {
 			si[uint(i)+sl.Offset] = models.StockMetadata{
 				Name:   sl.Names[i],
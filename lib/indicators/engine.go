@@ -0,0 +1,91 @@
+package indicators
+
+import (
+	"bursa-alert/lib/candles"
+	"sync"
+)
+
+// Indicator values for every stock, kept up to date from candle updates.
+// An indicator is only tracked for a stock once its value has been asked for
+type Engine struct {
+	Candles *candles.Builder
+
+	mu sync.Mutex
+	// Indicators of each candle series
+	states map[series]map[Spec]*state
+}
+
+type series struct {
+	id  uint
+	res candles.Resolution
+}
+
+// An indicator fed every closed candle, and the candle still being built
+type state struct {
+	indicator Indicator
+	current   candles.Candle
+	started   bool
+}
+
+func NewEngine(candles *candles.Builder) *Engine {
+	return &Engine{Candles: candles, states: make(map[series]map[Spec]*state)}
+}
+
+// Value of the indicator for the stock, including the candle being built.
+// False until enough candles have been seen
+func (e *Engine) Value(id uint, spec Spec) (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := series{id, spec.Resolution}
+	if e.states[k] == nil {
+		e.states[k] = make(map[Spec]*state)
+	}
+	st, ok := e.states[k][spec]
+	if !ok {
+		st = e.warm(id, spec)
+		e.states[k][spec] = st
+	}
+	if !st.started {
+		return 0, false
+	}
+	return st.indicator.Peek(st.current)
+}
+
+// Applies a candle update to every indicator tracked for its stock
+func (e *Engine) Feed(u candles.Update) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	states := e.states[series{u.Id, u.Resolution}]
+	for spec, st := range states {
+		switch {
+		case !st.started || u.Candle.Start.Equal(st.current.Start):
+			st.current, st.started = u.Candle, true
+		case u.Candle.Start.After(st.current.Start):
+			st.indicator.Push(st.current)
+			st.current = u.Candle
+		default:
+			// A late tick changed a closed candle
+			states[spec] = e.warm(u.Id, spec)
+		}
+	}
+}
+
+// Builds the indicator from the candles kept. Must hold e.mu
+func (e *Engine) warm(id uint, spec Spec) *state {
+	st := &state{indicator: spec.New(e.Candles.Calendar)}
+	kept := e.Candles.Series(id, spec.Resolution, 0)
+	for i, c := range kept {
+		if i == len(kept)-1 {
+			st.current, st.started = c, true
+			break
+		}
+		st.indicator.Push(c)
+	}
+	return st
+}
+
+func (e *Engine) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.states = make(map[series]map[Spec]*state)
+}
@@ -0,0 +1,302 @@
+// Technical indicators updated incrementally from candle series
+package indicators
+
+import (
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/session"
+	"math"
+	"time"
+)
+
+// An indicator over closed candles. Peek gives its value as if c were the
+// next candle, without adding it, so a bar still being built can be included
+type Indicator interface {
+	Push(c candles.Candle)
+	Peek(c candles.Candle) (float64, bool)
+}
+
+// The last n values pushed
+type window struct {
+	n      int
+	values []float64
+	// Index of the oldest value once full
+	next int
+	sum  float64
+}
+
+func newWindow(n int) *window {
+	return &window{n: n, values: make([]float64, 0, n)}
+}
+
+func (w *window) push(x float64) {
+	w.sum += x
+	if len(w.values) < w.n {
+		w.values = append(w.values, x)
+		return
+	}
+	w.sum -= w.values[w.next]
+	w.values[w.next] = x
+	w.next = (w.next + 1) % w.n
+}
+
+// Mean of the window with x pushed. False until the window would be full
+func (w *window) mean(x float64) (float64, bool) {
+	if len(w.values) < w.n-1 {
+		return 0, false
+	}
+	sum := w.sum + x
+	if len(w.values) == w.n {
+		sum -= w.values[w.next]
+	}
+	return sum / float64(w.n), true
+}
+
+// Population standard deviation of the window with x pushed
+func (w *window) stddev(x float64) (float64, bool) {
+	mean, ok := w.mean(x)
+	if !ok {
+		return 0, false
+	}
+	ss := (x - mean) * (x - mean)
+	for i, v := range w.values {
+		if len(w.values) == w.n && i == w.next {
+			continue
+		}
+		ss += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(ss / float64(w.n)), true
+}
+
+// Exponential moving average seeded with the simple average of the first n
+// values
+type ema struct {
+	n     int
+	k     float64
+	count int
+	value float64
+}
+
+func newEMA(n int) *ema {
+	return &ema{n: n, k: 2 / float64(n+1)}
+}
+
+func (e *ema) push(x float64) {
+	e.value, _ = e.peek(x)
+	e.count++
+}
+
+func (e *ema) peek(x float64) (float64, bool) {
+	switch {
+	case e.count < e.n-1:
+		return e.value + x, false
+	case e.count == e.n-1:
+		// value holds the sum of the seed values so far
+		return (e.value + x) / float64(e.n), true
+	default:
+		return e.value + e.k*(x-e.value), true
+	}
+}
+
+// Wilder's smoothing, as used by RSI and ATR
+type wilder struct {
+	n     int
+	count int
+	value float64
+}
+
+func (w *wilder) push(x float64) {
+	w.value, _ = w.peek(x)
+	w.count++
+}
+
+func (w *wilder) peek(x float64) (float64, bool) {
+	switch {
+	case w.count < w.n-1:
+		return w.value + x, false
+	case w.count == w.n-1:
+		return (w.value + x) / float64(w.n), true
+	default:
+		return (w.value*float64(w.n-1) + x) / float64(w.n), true
+	}
+}
+
+func closePrice(c candles.Candle) float64 {
+	return c.Close.Float64()
+}
+
+type sma struct {
+	w     *window
+	field func(candles.Candle) float64
+}
+
+func (s *sma) Push(c candles.Candle) {
+	s.w.push(s.field(c))
+}
+
+func (s *sma) Peek(c candles.Candle) (float64, bool) {
+	return s.w.mean(s.field(c))
+}
+
+type emaIndicator struct {
+	e *ema
+}
+
+func (e *emaIndicator) Push(c candles.Candle) {
+	e.e.push(closePrice(c))
+}
+
+func (e *emaIndicator) Peek(c candles.Candle) (float64, bool) {
+	return e.e.peek(closePrice(c))
+}
+
+type rsi struct {
+	gain, loss wilder
+	prev       float64
+	started    bool
+}
+
+func (r *rsi) Push(c candles.Candle) {
+	x := closePrice(c)
+	if r.started {
+		r.gain.push(max(x-r.prev, 0))
+		r.loss.push(max(r.prev-x, 0))
+	}
+	r.prev, r.started = x, true
+}
+
+func (r *rsi) Peek(c candles.Candle) (float64, bool) {
+	if !r.started {
+		return 0, false
+	}
+	x := closePrice(c)
+	gain, ok := r.gain.peek(max(x-r.prev, 0))
+	loss, _ := r.loss.peek(max(r.prev-x, 0))
+	if !ok {
+		return 0, false
+	}
+	if loss == 0 {
+		if gain == 0 {
+			return 50, true
+		}
+		return 100, true
+	}
+	return 100 - 100/(1+gain/loss), true
+}
+
+type macdOutput int
+
+const (
+	macdLine macdOutput = iota
+	macdSignal
+	macdHistogram
+)
+
+type macd struct {
+	fast, slow, signal *ema
+	output             macdOutput
+}
+
+func (m *macd) line(x float64) (float64, bool) {
+	fast, _ := m.fast.peek(x)
+	slow, ok := m.slow.peek(x)
+	return fast - slow, ok
+}
+
+func (m *macd) Push(c candles.Candle) {
+	x := closePrice(c)
+	if line, ok := m.line(x); ok {
+		m.signal.push(line)
+	}
+	m.fast.push(x)
+	m.slow.push(x)
+}
+
+func (m *macd) Peek(c candles.Candle) (float64, bool) {
+	line, ok := m.line(closePrice(c))
+	if !ok {
+		return 0, false
+	}
+	if m.output == macdLine {
+		return line, true
+	}
+	signal, ok := m.signal.peek(line)
+	if !ok {
+		return 0, false
+	}
+	if m.output == macdSignal {
+		return signal, true
+	}
+	return line - signal, true
+}
+
+type bollinger struct {
+	w *window
+	k float64
+	// -1 for the lower band, 0 for the middle and 1 for the upper
+	band float64
+}
+
+func (b *bollinger) Push(c candles.Candle) {
+	b.w.push(closePrice(c))
+}
+
+func (b *bollinger) Peek(c candles.Candle) (float64, bool) {
+	x := closePrice(c)
+	mean, ok := b.w.mean(x)
+	if !ok {
+		return 0, false
+	}
+	sd, _ := b.w.stddev(x)
+	return mean + b.band*b.k*sd, true
+}
+
+type atr struct {
+	tr      wilder
+	prev    float64
+	started bool
+}
+
+func (a *atr) trueRange(c candles.Candle) float64 {
+	high, low := c.High.Float64(), c.Low.Float64()
+	if !a.started {
+		return high - low
+	}
+	return max(high, a.prev) - min(low, a.prev)
+}
+
+func (a *atr) Push(c candles.Candle) {
+	a.tr.push(a.trueRange(c))
+	a.prev, a.started = closePrice(c), true
+}
+
+func (a *atr) Peek(c candles.Candle) (float64, bool) {
+	return a.tr.peek(a.trueRange(c))
+}
+
+// Volume weighted average price since the start of the trading day
+type vwap struct {
+	calendar *session.Calendar
+	day      time.Time
+	value    float64
+	volume   float64
+}
+
+func (v *vwap) Push(c candles.Candle) {
+	if day := v.calendar.Day(c.Start); !day.Equal(v.day) {
+		v.day, v.value, v.volume = day, 0, 0
+	}
+	v.value += c.Value.Float64()
+	v.volume += float64(c.Volume)
+}
+
+func (v *vwap) Peek(c candles.Candle) (float64, bool) {
+	value, volume := c.Value.Float64(), float64(c.Volume)
+	if v.calendar.Day(c.Start).Equal(v.day) {
+		value += v.value
+		volume += v.volume
+	}
+	if volume == 0 {
+		return 0, false
+	}
+	return value / volume, true
+}
@@ -0,0 +1,127 @@
+package indicators_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/indicators"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"math"
+	"testing"
+	"time"
+)
+
+func closes(prices ...float64) []candles.Candle {
+	out := make([]candles.Candle, len(prices))
+	for i, p := range prices {
+		price := models.Price(p * float64(models.Ringgit))
+		out[i] = candles.Candle{Open: price, High: price, Low: price, Close: price, Volume: 1, Value: price}
+	}
+	return out
+}
+
+// Value after pushing every candle but the last and peeking at it
+func value(t *testing.T, s string, cs []candles.Candle) (float64, bool) {
+	t.Helper()
+	spec, err := indicators.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ind := spec.New(session.New())
+	for _, c := range cs[:len(cs)-1] {
+		ind.Push(c)
+	}
+	return ind.Peek(cs[len(cs)-1])
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"rsi(14, 5m)", "macd(12, 26, 9, 1h)", "bollinger_upper(20, 2.5, 15m)", "vwap(1m)"} {
+		spec, err := indicators.Parse(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if spec.String() != s {
+			t.Errorf("%s printed as %s", s, spec)
+		}
+	}
+	for _, s := range []string{"rsi", "rsi(14)", "rsi(0, 5m)", "rsi(1.5, 5m)", "rsi(14, 2m)", "macd(26, 12, 9, 5m)", "bollinger_lower(20, 0, 5m)", "foo(1, 5m)"} {
+		if _, err := indicators.Parse(s); err == nil {
+			t.Errorf("%s accepted", s)
+		}
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	for _, tc := range []struct {
+		spec   string
+		closes []float64
+		want   float64
+	}{
+		{"sma(3, 1m)", []float64{1, 2, 3, 4}, 3},
+		// Seeded with the average of 1, 2, 3, then 2 + 0.5 * (4 - 2)
+		{"ema(3, 1m)", []float64{1, 2, 3, 4}, 3},
+		{"rsi(2, 1m)", []float64{1, 2, 3}, 100},
+		// Gains 1, 0 and losses 0, 1 average to equal strength
+		{"rsi(2, 1m)", []float64{1, 2, 1}, 50},
+		{"bollinger_middle(2, 2, 1m)", []float64{1, 3}, 2},
+		{"bollinger_upper(2, 2, 1m)", []float64{1, 3}, 4},
+		{"bollinger_lower(2, 2, 1m)", []float64{1, 3}, 0},
+		// True ranges 0, 1 and 2 with Wilder smoothing
+		{"atr(2, 1m)", []float64{1, 2, 4}, 1.25},
+		{"vwap(1m)", []float64{1, 2, 3}, 2},
+		{"volume_avg(2, 1m)", []float64{1, 2, 3}, 1},
+	} {
+		got, ok := value(t, tc.spec, closes(tc.closes...))
+		if !ok || !near(got, tc.want) {
+			t.Errorf("%s over %v: expected %f, got %f (%t)", tc.spec, tc.closes, tc.want, got, ok)
+		}
+	}
+	if _, ok := value(t, "sma(3, 1m)", closes(1, 2)); ok {
+		t.Error("sma ready before its period")
+	}
+
+	// The histogram is the line less the signal
+	prices := closes(1, 2, 3, 2, 4, 5, 4, 6, 7, 6)
+	line, ok1 := value(t, "macd(2, 4, 3, 1m)", prices)
+	signal, ok2 := value(t, "macd_signal(2, 4, 3, 1m)", prices)
+	histogram, ok3 := value(t, "macd_histogram(2, 4, 3, 1m)", prices)
+	if !ok1 || !ok2 || !ok3 || !near(histogram, line-signal) || line <= 0 {
+		t.Errorf("unexpected macd %f, %f, %f", line, signal, histogram)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	b := candles.NewBuilder(session.New(), 0)
+	e := indicators.NewEngine(b)
+	spec, _ := indicators.Parse("sma(3, 1m)")
+	add := func(price models.Price, at time.Time) {
+		entry := models.NewStockEntry(internal.StockEntry{StockIndex: 1, LastPrice: uint(price)})
+		for _, u := range b.Add(entry, at) {
+			e.Feed(u)
+		}
+	}
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, session.Location)
+	add(models.Ringgit, start)
+	if _, ok := e.Value(1, spec); ok {
+		t.Error("value before enough candles")
+	}
+	add(2*models.Ringgit, start.Add(time.Minute))
+	add(3*models.Ringgit, start.Add(2*time.Minute))
+	add(6*models.Ringgit, start.Add(2*time.Minute+time.Second))
+	if v, ok := e.Value(1, spec); !ok || !near(v, 3) {
+		t.Errorf("expected the building candle's close to count, got %f", v)
+	}
+	// A late tick into the first candle changes its close
+	add(4*models.Ringgit, start.Add(30*time.Second))
+	if v, _ := e.Value(1, spec); !near(v, 4) {
+		t.Errorf("late tick not applied, got %f", v)
+	}
+	if v, _ := indicators.NewEngine(b).Value(1, spec); !near(v, 4) {
+		t.Errorf("warmed engine disagrees, got %f", v)
+	}
+}
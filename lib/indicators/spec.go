@@ -0,0 +1,163 @@
+package indicators
+
+import (
+	"bursa-alert/lib/candles"
+	"bursa-alert/lib/session"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Name string
+
+const (
+	NameSMA             Name = "sma"
+	NameEMA             Name = "ema"
+	NameRSI             Name = "rsi"
+	NameMACD            Name = "macd"
+	NameMACDSignal      Name = "macd_signal"
+	NameMACDHistogram   Name = "macd_histogram"
+	NameBollingerUpper  Name = "bollinger_upper"
+	NameBollingerMiddle Name = "bollinger_middle"
+	NameBollingerLower  Name = "bollinger_lower"
+	NameATR             Name = "atr"
+	NameVWAP            Name = "vwap"
+	NameVolumeAvg       Name = "volume_avg"
+)
+
+var Names = []Name{
+	NameSMA, NameEMA, NameRSI, NameMACD, NameMACDSignal, NameMACDHistogram,
+	NameBollingerUpper, NameBollingerMiddle, NameBollingerLower, NameATR, NameVWAP, NameVolumeAvg,
+}
+
+// Arguments each indicator takes before its resolution
+var arity = map[Name]int{
+	NameSMA:             1,
+	NameEMA:             1,
+	NameRSI:             1,
+	NameMACD:            3,
+	NameMACDSignal:      3,
+	NameMACDHistogram:   3,
+	NameBollingerUpper:  2,
+	NameBollingerMiddle: 2,
+	NameBollingerLower:  2,
+	NameATR:             1,
+	NameVWAP:            0,
+	NameVolumeAvg:       1,
+}
+
+// An indicator with its parameters, written like rsi(14, 5m). Bollinger
+// bands take a period and a width in standard deviations, MACD its fast,
+// slow and signal periods and every other indicator but VWAP a period
+type Spec struct {
+	Name       Name
+	Args       [3]float64
+	Resolution candles.Resolution
+}
+
+// Number of arguments the indicator takes before its resolution, -1 if there
+// is no such indicator
+func Arity(name Name) int {
+	if n, ok := arity[name]; ok {
+		return n
+	}
+	return -1
+}
+
+func Parse(s string) (Spec, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return Spec{}, fmt.Errorf("%s is not an indicator call", s)
+	}
+	spec := Spec{Name: Name(strings.TrimSpace(s[:open]))}
+	n, ok := arity[spec.Name]
+	if !ok {
+		return Spec{}, fmt.Errorf("%s is not a valid indicator", spec.Name)
+	}
+	args := strings.Split(s[open+1:len(s)-1], ",")
+	if len(args) != n+1 {
+		return Spec{}, fmt.Errorf("%s takes %d arguments and a resolution", spec.Name, n)
+	}
+	for i, arg := range args[:n] {
+		f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if err != nil {
+			return Spec{}, fmt.Errorf("%s: %s is not a number", spec.Name, strings.TrimSpace(arg))
+		}
+		spec.Args[i] = f
+	}
+	spec.Resolution = candles.Resolution(strings.TrimSpace(args[n]))
+	return spec, spec.Validate()
+}
+
+func (s Spec) Validate() error {
+	n, ok := arity[s.Name]
+	if !ok {
+		return fmt.Errorf("%s is not a valid indicator", s.Name)
+	}
+	if err := s.Resolution.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", s.Name, err)
+	}
+	for i, arg := range s.Args[:n] {
+		// The Bollinger width is the only argument that is not a period
+		if i == 1 && s.bollinger() {
+			if arg <= 0 {
+				return fmt.Errorf("%s: width must be positive", s.Name)
+			}
+			continue
+		}
+		if arg < 1 || arg != math.Trunc(arg) {
+			return fmt.Errorf("%s: %s is not a valid period", s.Name, strconv.FormatFloat(arg, 'f', -1, 64))
+		}
+	}
+	if s.macd() && s.Args[0] >= s.Args[1] {
+		return fmt.Errorf("%s: fast period must be shorter than the slow one", s.Name)
+	}
+	return nil
+}
+
+func (s Spec) String() string {
+	args := make([]string, 0, arity[s.Name]+1)
+	for _, arg := range s.Args[:arity[s.Name]] {
+		args = append(args, strconv.FormatFloat(arg, 'f', -1, 64))
+	}
+	args = append(args, string(s.Resolution))
+	return fmt.Sprintf("%s(%s)", s.Name, strings.Join(args, ", "))
+}
+
+func (s Spec) bollinger() bool {
+	return s.Name == NameBollingerUpper || s.Name == NameBollingerMiddle || s.Name == NameBollingerLower
+}
+
+func (s Spec) macd() bool {
+	return s.Name == NameMACD || s.Name == NameMACDSignal || s.Name == NameMACDHistogram
+}
+
+// A fresh indicator for a valid spec
+func (s Spec) New(calendar *session.Calendar) Indicator {
+	period := int(s.Args[0])
+	switch s.Name {
+	case NameSMA:
+		return &sma{w: newWindow(period), field: closePrice}
+	case NameEMA:
+		return &emaIndicator{e: newEMA(period)}
+	case NameRSI:
+		return &rsi{gain: wilder{n: period}, loss: wilder{n: period}}
+	case NameMACD, NameMACDSignal, NameMACDHistogram:
+		output := map[Name]macdOutput{NameMACD: macdLine, NameMACDSignal: macdSignal, NameMACDHistogram: macdHistogram}[s.Name]
+		return &macd{fast: newEMA(period), slow: newEMA(int(s.Args[1])), signal: newEMA(int(s.Args[2])), output: output}
+	case NameBollingerUpper, NameBollingerMiddle, NameBollingerLower:
+		band := map[Name]float64{NameBollingerUpper: 1, NameBollingerMiddle: 0, NameBollingerLower: -1}[s.Name]
+		return &bollinger{w: newWindow(period), k: s.Args[1], band: band}
+	case NameATR:
+		return &atr{tr: wilder{n: period}}
+	case NameVWAP:
+		return &vwap{calendar: calendar}
+	case NameVolumeAvg:
+		return &sma{w: newWindow(period), field: func(c candles.Candle) float64 {
+			return float64(c.Volume)
+		}}
+	default:
+		panic("invalid indicator")
+	}
+}
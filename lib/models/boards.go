@@ -0,0 +1,78 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Board string
+
+const (
+	BoardMain Board = "main"
+	BoardACE  Board = "ace"
+	BoardLEAP Board = "leap"
+	BoardETF  Board = "etf"
+)
+
+var Boards = []Board{BoardMain, BoardACE, BoardLEAP, BoardETF}
+
+// The board of each stock code. Upstream metadata carries no board, and codes
+// are kept when a stock moves board, so it cannot be told from the code
+type BoardTable map[string]Board
+
+// Table used for board selectors. Empty until one is loaded at startup
+var BoardCodes = BoardTable{}
+
+func (b Board) Valid() bool {
+	for _, board := range Boards {
+		if b == board {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads a board table, as YAML lists of stock codes keyed by board
+func LoadBoards(path string) (BoardTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lists map[Board][]string
+	if err := yaml.Unmarshal(b, &lists); err != nil {
+		return nil, err
+	}
+	t := make(BoardTable)
+	for board, codes := range lists {
+		if !board.Valid() {
+			return nil, fmt.Errorf("%s is not a valid board", board)
+		}
+		for _, code := range codes {
+			if other, ok := t[code]; ok {
+				return nil, fmt.Errorf("%s is listed on both %s and %s", code, other, board)
+			}
+			t[code] = board
+		}
+	}
+	return t, nil
+}
+
+// Warrants and other derivatives carry their underlying's code with a suffix
+var underlying = regexp.MustCompile(`^\d+`)
+
+// The board the stock with code trades on, or that of its underlying. Empty
+// when the table does not list either
+func (t BoardTable) Board(code string) Board {
+	if b, ok := t[code]; ok {
+		return b
+	}
+	return t[underlying.FindString(code)]
+}
+
+// The board the stock trades on, from BoardCodes
+func (m StockMetadata) Board() Board {
+	return BoardCodes.Board(m.Ticker)
+}
@@ -0,0 +1,45 @@
+package models_test
+
+import (
+	"bursa-alert/lib/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boards.yaml")
+	if err := os.WriteFile(path, []byte(`
+main: ["1155", "0138"]
+ace: ["0259"]
+etf: ["0822EA"]
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	boards, err := models.LoadBoards(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, board := range map[string]models.Board{
+		"1155":   models.BoardMain,
+		"0138":   models.BoardMain,
+		"0138WB": models.BoardMain,
+		"0259":   models.BoardACE,
+		"0822EA": models.BoardETF,
+		"0250":   "",
+		"ABC":    "",
+	} {
+		if b := boards.Board(code); b != board {
+			t.Errorf("%s is on %q, expected %q", code, b, board)
+		}
+	}
+
+	for _, bad := range []string{`{main: ["0138"], ace: ["0138"]}`, `{otc: ["0138"]}`} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := models.LoadBoards(path); err == nil {
+			t.Errorf("%s loaded", bad)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An exact price in milicents, thousandths of a ringgit, as sent upstream
+type Price int64
+
+const (
+	Milicent Price = 1
+	Sen      Price = 10
+	Ringgit  Price = 1000
+)
+
+// Parses a decimal ringgit amount such as 1.235 or -0.5. More than three
+// decimal places cannot be represented and are rejected, as are amounts
+// beyond the range of Price
+func ParsePrice(s string) (Price, error) {
+	s = strings.TrimSpace(s)
+	digits, neg := s, false
+	if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+		digits, neg = digits[1:], digits[0] == '-'
+	}
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return 0, errors.New("empty price")
+	}
+	if len(frac) > 3 {
+		return 0, fmt.Errorf("%s has more than 3 decimal places", s)
+	}
+	var f int64
+	if frac != "" {
+		n, err := strconv.ParseUint(frac+strings.Repeat("0", 3-len(frac)), 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a price", s)
+		}
+		f = int64(n)
+	}
+	var w int64
+	if whole != "" {
+		n, err := strconv.ParseUint(whole, 10, 63)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a price", s)
+		}
+		if n > uint64(math.MaxInt64-f)/uint64(Ringgit) {
+			return 0, fmt.Errorf("%s is too large", s)
+		}
+		w = int64(n)
+	}
+	p := w*int64(Ringgit) + f
+	if neg {
+		p = -p
+	}
+	return Price(p), nil
+}
+
+// Ringgit with three decimal places, e.g. 1.005
+func (p Price) String() string {
+	sign := ""
+	if p < 0 {
+		sign, p = "-", -p
+	}
+	return fmt.Sprintf("%s%d.%03d", sign, p/Ringgit, p%Ringgit)
+}
+
+func (p Price) Float64() float64 {
+	return float64(p) / float64(Ringgit)
+}
+
+// p times n, e.g. the value of n shares
+func (p Price) Mul(n int64) Price {
+	return p * Price(n)
+}
+
+func (p Price) Abs() Price {
+	if p < 0 {
+		return -p
+	}
+	return p
+}
+
+// Written as an exact decimal number
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// Accepts a number or a string
+func (p *Price) UnmarshalJSON(b []byte) error {
+	parsed, err := ParsePrice(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Used by YAML and other text encodings
+func (p Price) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Price) UnmarshalText(b []byte) error {
+	parsed, err := ParsePrice(string(b))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
@@ -0,0 +1,55 @@
+package models_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/models"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	for s, want := range map[string]models.Price{
+		"1.235":                1235,
+		"0.5":                  500,
+		"12":                   12000,
+		".005":                 5,
+		"-0.01":                -10,
+		"+2.5":                 2500,
+		"9223372036854775.807": math.MaxInt64,
+	} {
+		p, err := models.ParsePrice(s)
+		if err != nil || p != want {
+			t.Errorf("%s parsed as %d, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"", "1.2345", "abc", "1.x", "--1", "-+1", "+-1", "1.-5", "1.+5", "9223372036854775.808", "99999999999999999999"} {
+		if _, err := models.ParsePrice(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+	if s := models.Price(-1005).String(); s != "-1.005" {
+		t.Errorf("formatted as %s", s)
+	}
+}
+
+func TestPriceJSON(t *testing.T) {
+	e := models.NewStockEntry(internal.StockEntry{LastPrice: 1005, PreclosePrice: 1000, IsPurchase: 1, TotalBoughtQuantity: 3})
+	b, err := json.Marshal(e.ToMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]json.RawMessage
+	_ = json.Unmarshal(b, &m)
+	if string(m["last_price"]) != "1.005" || string(m["price_change"]) != "0.005" || string(m["buy_rate"]) != "null" {
+		t.Errorf("unexpected json %s", b)
+	}
+	// Sen are no longer truncated before multiplying
+	if v := e.GetTradeValue(); v != 3015 {
+		t.Errorf("trade value %s", v)
+	}
+	var p models.Price
+	if err := json.Unmarshal(m["last_price"], &p); err != nil || p != 1005 {
+		t.Errorf("round trip gave %s, %v", p, err)
+	}
+}
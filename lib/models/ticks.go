@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prices from Min upwards trade in multiples of Tick, up to the next band
+type TickBand struct {
+	Min  Price `yaml:"min" json:"min"`
+	Tick Price `yaml:"tick" json:"tick"`
+}
+
+// Bands in ascending order, the first starting at zero
+type TickTable []TickBand
+
+// Bursa's bands for stocks, ETFs and warrants
+var DefaultTicks = TickTable{
+	{Min: 0, Tick: 5 * Milicent},
+	{Min: 1 * Ringgit, Tick: 1 * Sen},
+	{Min: 10 * Ringgit, Tick: 2 * Sen},
+	{Min: 100 * Ringgit, Tick: 10 * Sen},
+}
+
+// Table used for rounding and tick variables. Replace it at startup to follow
+// changes to Bursa's rules
+var Ticks = DefaultTicks
+
+func (t TickTable) Validate() error {
+	if len(t) == 0 || t[0].Min != 0 {
+		return errors.New("the first tick band must start at 0")
+	}
+	for i, band := range t {
+		if band.Tick <= 0 {
+			return fmt.Errorf("tick band from %s has no tick size", band.Min)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := t[i-1]
+		if band.Min <= prev.Min {
+			return fmt.Errorf("tick band from %s is out of order", band.Min)
+		}
+		// Band edges must be valid prices in both bands
+		if band.Min%band.Tick != 0 || band.Min%prev.Tick != 0 {
+			return fmt.Errorf("tick band from %s does not start on a tick", band.Min)
+		}
+	}
+	return nil
+}
+
+// Reads and validates a tick table, as a YAML list of min and tick
+func LoadTicks(path string) (TickTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t TickTable
+	if err := yaml.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return t, t.Validate()
+}
+
+func (t TickTable) band(p Price) int {
+	i := 0
+	for i+1 < len(t) && p >= t[i+1].Min {
+		i++
+	}
+	return i
+}
+
+// Tick size at p
+func (t TickTable) Size(p Price) Price {
+	return t[t.band(p)].Tick
+}
+
+func (t TickTable) Valid(p Price) bool {
+	return p >= 0 && p%t.Size(p) == 0
+}
+
+// Nearest valid price, halfway prices rounding up
+func (t TickTable) Round(p Price) Price {
+	if p <= 0 {
+		return 0
+	}
+	tick := t.Size(p)
+	lower := p - p%tick
+	if 2*(p-lower) >= tick {
+		return lower + tick
+	}
+	return lower
+}
+
+// Lowest valid price above p
+func (t TickTable) Next(p Price) Price {
+	if p < 0 {
+		return 0
+	}
+	tick := t.Size(p)
+	return p - p%tick + tick
+}
+
+// Highest valid price below p, or 0
+func (t TickTable) Prev(p Price) Price {
+	if p <= 0 {
+		return 0
+	}
+	tick := t.Size(p)
+	if p%tick != 0 {
+		return p - p%tick
+	}
+	p--
+	return p - p%t.Size(p)
+}
+
+// Signed number of ticks from a to b, after rounding both to valid prices
+func (t TickTable) Between(a, b Price) int {
+	a, b = t.Round(a), t.Round(b)
+	if a > b {
+		return -t.Between(b, a)
+	}
+	ticks := 0
+	for i, band := range t {
+		hi := b
+		if i+1 < len(t) {
+			hi = min(hi, t[i+1].Min)
+		}
+		lo := max(a, band.Min)
+		if hi > lo {
+			ticks += int((hi - lo) / band.Tick)
+		}
+	}
+	return ticks
+}
@@ -0,0 +1,53 @@
+package models_test
+
+import (
+	"bursa-alert/lib/models"
+	"testing"
+)
+
+func TestTicks(t *testing.T) {
+	ticks := models.DefaultTicks
+	if err := ticks.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		p, round, next, prev models.Price
+	}{
+		{995, 995, 1000, 990},
+		{1000, 1000, 1010, 995},
+		{1003, 1000, 1010, 1000},
+		{1005, 1010, 1010, 1000},
+		{9997, 10000, 10000, 9990},
+		{10000, 10000, 10020, 9990},
+		{100000, 100000, 100100, 99980},
+		{5, 5, 10, 0},
+	} {
+		if r := ticks.Round(c.p); r != c.round {
+			t.Errorf("Round(%s) = %s, expected %s", c.p, r, c.round)
+		}
+		if n := ticks.Next(c.p); n != c.next {
+			t.Errorf("Next(%s) = %s, expected %s", c.p, n, c.next)
+		}
+		if p := ticks.Prev(c.p); p != c.prev {
+			t.Errorf("Prev(%s) = %s, expected %s", c.p, p, c.prev)
+		}
+	}
+	// 0.950 to 1.000 in half sen, then 1.00 to 1.05 in sen
+	if n := ticks.Between(950, 1050); n != 15 {
+		t.Errorf("expected 15 ticks, got %d", n)
+	}
+	if n := ticks.Between(1050, 950); n != -15 {
+		t.Errorf("expected -15 ticks, got %d", n)
+	}
+
+	for _, bad := range []models.TickTable{
+		nil,
+		{{Min: 1000, Tick: 10}},
+		{{Min: 0, Tick: 5}, {Min: 1002, Tick: 10}},
+		{{Min: 0, Tick: 5}, {Min: 1000, Tick: 0}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%v is valid", bad)
+		}
+	}
+}
@@ -2,44 +2,65 @@ package models
 
 import (
 	"bursa-alert/internal"
+	"bursa-alert/lib/session"
+	"math"
+	"slices"
+	"time"
 )
 
 type StockEntry struct {
 	internal internal.StockEntry
+	phase    session.Phase
 }
 
-func (s StockEntry) ToMap() map[string]float32 {
-	return map[string]float32{
+// Values sent to clients. Prices are written as exact decimals
+func (s StockEntry) ToMap() map[string]any {
+	m := map[string]any{
 		"last_price":            s.GetLastPrice(),
 		"preclose_price":        s.GetPreclosePrice(),
-		"price_change":          float32(s.GetPriceChange()),
-		"total_bought_quantity": float32(s.GetTotalBoughtQuantity()),
-		"trade_value":           float32(s.GetTradeValue()),
+		"price_change":          s.GetPriceChange(),
+		"total_bought_quantity": s.GetTotalBoughtQuantity(),
+		"trade_value":           s.GetTradeValue(),
 		"buy_value":             s.GetBuyValue(),
-		"buy_volume":            float32(s.GetBuyVolume()),
-		"sell_volume":           float32(s.GetSellVolume()),
+		"buy_volume":            s.GetBuyVolume(),
+		"sell_volume":           s.GetSellVolume(),
 		"buy_rate":              s.GetBuyRate(),
 	}
+	// Undefined until something trades, and JSON has no NaN
+	if math.IsNaN(float64(s.GetBuyRate())) {
+		m["buy_rate"] = nil
+	}
+	return m
 }
 
 func NewStockEntry(i internal.StockEntry) StockEntry {
-	return StockEntry{i}
+	return StockEntry{internal: i}
+}
+
+// Market phase the entry was received in, empty until tagged
+func (s StockEntry) GetPhase() session.Phase {
+	return s.phase
+}
+
+func (s StockEntry) WithPhase(p session.Phase) StockEntry {
+	s.phase = p
+	return s
 }
 
 func (s StockEntry) GetIndex() uint {
 	return s.internal.StockIndex
 }
 
-func (s StockEntry) GetLastPrice() float32 {
-	return float32(s.internal.LastPrice) / 1000
+func (s StockEntry) GetLastPrice() Price {
+	return Price(s.internal.LastPrice)
 }
 
-func (s StockEntry) GetPreclosePrice() float32 {
-	return float32(s.internal.PreclosePrice) / 1000
+func (s StockEntry) GetPreclosePrice() Price {
+	return Price(s.internal.PreclosePrice)
 }
 
-func (s StockEntry) GetPriceChange() int {
-	return int(s.internal.LastPrice) - int(s.internal.PreclosePrice)
+func (s StockEntry) GetPriceChange() Price {
+	return s.GetLastPrice() - s.GetPreclosePrice()
 }
 
 func (s StockEntry) GetTotalBoughtQuantity() uint {
@@ -49,18 +70,15 @@ func (s StockEntry) GetTotalBoughtQuantity() uint {
 	return s.internal.TotalBoughtQuantity * 100
 }
 
-func (s StockEntry) GetTradeValue() uint {
+func (s StockEntry) GetTradeValue() Price {
 	if s.internal.IsPurchase != 1 {
 		return 0
 	}
-	return s.internal.LastPrice / 1000 * s.internal.TotalBoughtQuantity
+	return s.GetLastPrice().Mul(int64(s.internal.TotalBoughtQuantity))
 }
 
-func (s StockEntry) GetBuyValue() float32 {
-	if s.internal.BuyValue == 0 {
-		return 0
-	}
-	return float32(s.internal.BuyValue) / 1000
+func (s StockEntry) GetBuyValue() Price {
+	return Price(s.internal.BuyValue)
 }
 
 func (s StockEntry) GetBuyVolume() uint {
@@ -81,3 +99,63 @@ type StockMetadata struct {
 	Id     int
 }
 
+type MetadataEventKind string
+
+const (
+	EventListing      MetadataEventKind = "listing"
+	EventDelisting    MetadataEventKind = "delisting"
+	EventNameChange   MetadataEventKind = "name_change"
+	EventTickerChange MetadataEventKind = "ticker_change"
+)
+
+var MetadataEventKinds = []MetadataEventKind{EventListing, EventDelisting, EventNameChange, EventTickerChange}
+
+// A change to a stock's metadata between two refreshes
+type MetadataEvent struct {
+	Kind MetadataEventKind `json:"kind"`
+	Id   uint              `json:"id"`
+	// Zero for listings
+	Old StockMetadata `json:"old"`
+	// Zero for delistings
+	New StockMetadata `json:"new"`
+	At  time.Time     `json:"at"`
+}
+
+// Metadata the event is about, the new one unless the stock was delisted
+func (e MetadataEvent) Stock() StockMetadata {
+	if e.Kind == EventDelisting {
+		return e.Old
+	}
+	return e.New
+}
+
+// Changes from old to new, ordered by stock id
+func DiffMetadata(old, new map[uint]StockMetadata, at time.Time) []MetadataEvent {
+	var events []MetadataEvent
+	for id, n := range new {
+		o, ok := old[id]
+		switch {
+		case !ok:
+			events = append(events, MetadataEvent{Kind: EventListing, Id: id, New: n, At: at})
+		default:
+			if o.Ticker != n.Ticker {
+				events = append(events, MetadataEvent{Kind: EventTickerChange, Id: id, Old: o, New: n, At: at})
+			}
+			if o.Name != n.Name {
+				events = append(events, MetadataEvent{Kind: EventNameChange, Id: id, Old: o, New: n, At: at})
+			}
+		}
+	}
+	for id, o := range old {
+		if _, ok := new[id]; !ok {
+			events = append(events, MetadataEvent{Kind: EventDelisting, Id: id, Old: o, At: at})
+		}
+	}
+	slices.SortFunc(events, func(a, b MetadataEvent) int {
+		if a.Id != b.Id {
+			return int(a.Id) - int(b.Id)
+		}
+		return slices.Index(MetadataEventKinds, a.Kind) - slices.Index(MetadataEventKinds, b.Kind)
+	})
+	return events
+}
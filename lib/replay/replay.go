@@ -0,0 +1,307 @@
+// Plays back sessions written by ws.Recorder through the usual message handlers
+package replay
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/handlers"
+	"bursa-alert/lib/models"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errSeek = errors.New("seek")
+
+type Player struct {
+	paths    []string
+	handlers map[string]ws.MessageHandler
+	// Called when playback restarts from the beginning so derived state can be cleared
+	Reset func()
+
+	mu     sync.Mutex
+	wake   chan struct{}
+	speed  float64
+	paused bool
+	seekTo *time.Duration
+	// Position within the recording and the wall clock of the last frame
+	pos    time.Duration
+	at     time.Time
+	frames int
+	// Real time at which pos was reached, used for pacing
+	anchor time.Time
+}
+
+// Paths may be recording files or directories of them, played in lexical order
+func New(paths ...string) (*Player, error) {
+	p := &Player{
+		handlers: make(map[string]ws.MessageHandler),
+		wake:     make(chan struct{}, 1),
+		speed:    1,
+	}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(path, ".jsonl.gz") {
+				p.paths = append(p.paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(p.paths) == 0 {
+		return nil, errors.New("no recordings found")
+	}
+	slices.Sort(p.paths)
+	return p, nil
+}
+
+// Handlers are called with a nil connection
+func (p *Player) AddHandler(mt string, f ws.MessageHandler) {
+	p.handlers[mt] = f
+}
+
+// Playback speed as a multiple of real time. 0 plays as fast as possible
+func (p *Player) SetSpeed(x float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.speed = x
+	p.anchor = time.Now()
+	p.signal()
+}
+
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	p.signal()
+}
+
+func (p *Player) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.anchor = time.Now()
+	p.signal()
+}
+
+// Jumps to d from the start of the recording. Frames up to d are still
+// dispatched, without delay, so snapshots stay consistent
+func (p *Player) Seek(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seekTo = &d
+	p.signal()
+}
+
+type Status struct {
+	Position time.Duration `json:"position"`
+	At       time.Time     `json:"at"`
+	Speed    float64       `json:"speed"`
+	Paused   bool          `json:"paused"`
+}
+
+func (p *Player) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Status{Position: p.pos, At: p.at, Speed: p.speed, Paused: p.paused}
+}
+
+// Recorded wall clock of the last dispatched frame
+func (p *Player) Now() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.at
+}
+
+func (p *Player) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Reads the SL frames in the recordings without playing them
+func (p *Player) Metadata(_ context.Context) (map[uint]models.StockMetadata, error) {
+	var mu sync.Mutex
+	m := make(map[uint]models.StockMetadata)
+	mapping := handlers.GetStockMapping(m, &mu)
+	for _, path := range p.paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = ws.ReadFrames(f, func(frame ws.Frame) error {
+			if frame.Dir != ws.DirIn || frame.Mt != "SL" {
+				return nil
+			}
+			return mapping(nil, frame.Data)
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Plays MT/SM frames for ids through the shared snapshots. Snapshots, history,
+// candles and indicators are cleared whenever playback restarts unless Reset
+// is set
+func (p *Player) Stream(ctx context.Context, ids []uint, ch chan<- models.StockEntry) error {
+	want := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	filtered := make(chan models.StockEntry)
+	p.AddHandler("MT", lib.StockHandler("MT", filtered))
+	p.AddHandler("SM", lib.StockHandler("SM", filtered))
+	if p.Reset == nil {
+		p.Reset = func() {
+			global.Snapshots.Reset()
+			global.Entries.Reset()
+			global.Candles.Reset()
+			global.Indicators.Reset()
+		}
+	}
+	go func() {
+		for entry := range filtered {
+			if want[entry.GetIndex()] {
+				ch <- entry
+			}
+		}
+	}()
+	defer close(filtered)
+	return p.Run(ctx)
+}
+
+// Plays the recordings until they end or ctx is cancelled
+func (p *Player) Run(ctx context.Context) error {
+	target := time.Duration(0)
+	for {
+		err := p.play(ctx, target)
+		if !errors.Is(err, errSeek) {
+			return err
+		}
+		p.mu.Lock()
+		target = *p.seekTo
+		p.seekTo = nil
+		restart := target < p.pos
+		p.mu.Unlock()
+		if !restart {
+			// Keep playing from where we are, fast forwarding to target
+			continue
+		}
+		if p.Reset != nil {
+			p.Reset()
+		}
+	}
+}
+
+func (p *Player) play(ctx context.Context, fastForward time.Duration) error {
+	p.mu.Lock()
+	if fastForward < p.pos || p.at.IsZero() {
+		p.pos, p.frames = 0, 0
+	}
+	// Frames already dispatched are skipped when resuming after a forward seek
+	skip := p.frames
+	p.anchor = time.Now()
+	p.mu.Unlock()
+
+	// Position is the sum of the gaps between frames. Gaps across separate
+	// recording runs, where the monotonic clock restarts, are dropped
+	pos := time.Duration(0)
+	var last int64
+	n := 0
+	for _, path := range p.paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = ws.ReadFrames(f, func(frame ws.Frame) error {
+			if frame.Dir != ws.DirIn || frame.Mt == "" {
+				return nil
+			}
+			if n > 0 && frame.T > last {
+				pos += time.Duration(frame.T - last)
+			}
+			last = frame.T
+			n++
+			if n <= skip {
+				return nil
+			}
+			if pos > fastForward {
+				if err := p.wait(ctx, pos); err != nil {
+					return err
+				}
+			}
+			p.mu.Lock()
+			if pos <= fastForward {
+				p.anchor = time.Now()
+			}
+			p.pos = pos
+			p.at = time.UnixMilli(frame.At)
+			p.frames = n
+			p.mu.Unlock()
+			if h, ok := p.handlers[frame.Mt]; ok {
+				return h(nil, frame.Data)
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Blocks until the frame at pos is due
+func (p *Player) wait(ctx context.Context, pos time.Duration) error {
+	for {
+		p.mu.Lock()
+		if p.seekTo != nil {
+			p.mu.Unlock()
+			return errSeek
+		}
+		paused, speed := p.paused, p.speed
+		due := p.anchor
+		if speed > 0 {
+			due = due.Add(time.Duration(float64(pos-p.pos) / speed))
+		}
+		p.mu.Unlock()
+
+		if !paused && (speed == 0 || !time.Now().Before(due)) {
+			p.mu.Lock()
+			p.anchor = due
+			p.mu.Unlock()
+			return nil
+		}
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !paused {
+			timer = time.NewTimer(time.Until(due))
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
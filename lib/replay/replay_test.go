@@ -0,0 +1,109 @@
+package replay_test
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/replay"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRecording(t *testing.T, frames []ws.Frame) string {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "001.jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, frame := range frames {
+		if err := enc.Encode(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gz.Close()
+	f.Close()
+	return dir
+}
+
+func frame(sec int, mt, data string) ws.Frame {
+	return ws.Frame{T: int64(sec) * int64(time.Second), At: int64(sec) * 1000, Dir: ws.DirIn, Mt: mt, Data: json.RawMessage(data)}
+}
+
+func TestReplay(t *testing.T) {
+	dir := writeRecording(t, []ws.Frame{
+		frame(0, "MT", `{"1": 1, "209": 1000, "153": 990}`),
+		{T: 0, Dir: ws.DirOut, Mt: "AC", Data: json.RawMessage(`{}`)},
+		frame(60, "SM", `{"1": 1, "114": 10, "117": 10}`),
+		frame(120, "MT", `{"1": 1, "209": 1010}`),
+	})
+	p, err := replay.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.Snapshots.Reset()
+	ch := make(chan models.StockEntry, 10)
+	p.AddHandler("MT", lib.StockHandler("MT", ch))
+	p.AddHandler("SM", lib.StockHandler("SM", ch))
+	p.SetSpeed(0)
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var entries []models.StockEntry
+	for e := range ch {
+		entries = append(entries, e)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	last := entries[2]
+	if last.GetLastPrice() != 1010 || last.GetPreclosePrice() != 990 || last.GetBuyRate() != 0.5 {
+		t.Errorf("replayed entry not merged: %v", last.ToMap())
+	}
+	if s := p.Status(); s.Position != 2*time.Minute || !s.At.Equal(time.UnixMilli(120000)) {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	dir := writeRecording(t, []ws.Frame{
+		frame(0, "MT", `{"1": 1, "209": 1000}`),
+		frame(3600, "MT", `{"1": 1, "209": 1001}`),
+		frame(7200, "MT", `{"1": 1, "209": 1002}`),
+	})
+	p, err := replay.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan models.StockEntry, 10)
+	p.AddHandler("MT", lib.StockHandler("MT", ch))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	// The second frame is an hour away at 1x, so seeking is the only way to reach it
+	<-ch
+	p.Seek(2 * time.Hour)
+	for _, want := range []models.Price{1001, 1002} {
+		select {
+		case e := <-ch:
+			if e.GetLastPrice() != want {
+				t.Errorf("expected %s, got %s", want, e.GetLastPrice())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("seek did not fast forward")
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+// Bursa Malaysia trading hours and market phases
+package session
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Phase string
+
+const (
+	PhasePreOpen          Phase = "pre_open"
+	PhaseMorning          Phase = "morning"
+	PhaseLunch            Phase = "lunch"
+	PhasePreOpenAfternoon Phase = "pre_open_afternoon"
+	PhaseAfternoon        Phase = "afternoon"
+	PhasePreClose         Phase = "pre_close"
+	PhaseTradingAtLast    Phase = "trading_at_last"
+	PhaseClosed           Phase = "closed"
+)
+
+// Every phase in the order they occur on a trading day
+var Phases = []Phase{PhasePreOpen, PhaseMorning, PhaseLunch, PhasePreOpenAfternoon, PhaseAfternoon, PhasePreClose, PhaseTradingAtLast, PhaseClosed}
+
+// Whether orders are matched continuously
+func (p Phase) Trading() bool {
+	return p == PhaseMorning || p == PhaseAfternoon
+}
+
+func (p Phase) Valid() bool {
+	for _, phase := range Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// A phase and the time of day it starts
+type Period struct {
+	Start time.Duration
+	Phase Phase
+}
+
+// The trading day, starting at pre-open. Anything after the last period is closed
+var Schedule = []Period{
+	{8*time.Hour + 30*time.Minute, PhasePreOpen},
+	{9 * time.Hour, PhaseMorning},
+	{12*time.Hour + 30*time.Minute, PhaseLunch},
+	{14 * time.Hour, PhasePreOpenAfternoon},
+	{14*time.Hour + 30*time.Minute, PhaseAfternoon},
+	{16*time.Hour + 45*time.Minute, PhasePreClose},
+	{16*time.Hour + 50*time.Minute, PhaseTradingAtLast},
+	{17 * time.Hour, PhaseClosed},
+}
+
+// A continuous trading session and the pre-open auction leading into it, as
+// times of day. Close includes pre-close and trading-at-last
+type Session struct {
+	PreOpen time.Duration
+	Open    time.Duration
+	Close   time.Duration
+}
+
+// The morning and afternoon sessions, matching Schedule
+var Sessions = []Session{
+	{8*time.Hour + 30*time.Minute, 9 * time.Hour, 12*time.Hour + 30*time.Minute},
+	{14 * time.Hour, 14*time.Hour + 30*time.Minute, 17 * time.Hour},
+}
+
+// Asia/Kuala_Lumpur, or the equivalent fixed zone when tzdata is missing.
+// Malaysia has not changed its offset since 1982
+var Location = loadLocation()
+
+func loadLocation() *time.Location {
+	loc, err := time.LoadLocation("Asia/Kuala_Lumpur")
+	if err != nil {
+		return time.FixedZone("MYT", 8*60*60)
+	}
+	return loc
+}
+
+var Default = New()
+
+// Trading days and phases, closed on weekends and holidays
+type Calendar struct {
+	mu sync.RWMutex
+	// Names keyed by date, as 2006-01-02
+	holidays map[string]string
+}
+
+func New() *Calendar {
+	return &Calendar{holidays: make(map[string]string)}
+}
+
+type Holiday struct {
+	Date string `yaml:"date"`
+	Name string `yaml:"name"`
+}
+
+type holidayFile struct {
+	Holidays []Holiday `yaml:"holidays"`
+}
+
+// Adds the holidays listed in a YAML file
+func (c *Calendar) LoadHolidays(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f holidayFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	for _, h := range f.Holidays {
+		if _, err := time.ParseInLocation(time.DateOnly, h.Date, Location); err != nil {
+			return fmt.Errorf("holiday %s: %w", h.Name, err)
+		}
+	}
+	c.AddHolidays(f.Holidays...)
+	return nil
+}
+
+func (c *Calendar) AddHolidays(holidays ...Holiday) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range holidays {
+		c.holidays[h.Date] = h.Name
+	}
+}
+
+// Name of the holiday on t's date in Kuala Lumpur
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name, ok := c.holidays[t.In(Location).Format(time.DateOnly)]
+	return name, ok
+}
+
+func (c *Calendar) TradingDay(t time.Time) bool {
+	switch t.In(Location).Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+func (c *Calendar) Phase(t time.Time) Phase {
+	if !c.TradingDay(t) {
+		return PhaseClosed
+	}
+	offset := t.Sub(midnight(t))
+	phase := PhaseClosed
+	for _, p := range Schedule {
+		if offset < p.Start {
+			break
+		}
+		phase = p.Phase
+	}
+	return phase
+}
+
+// Open and close of the session t falls in, counting its pre-open. False on
+// closed days and outside sessions, e.g. over lunch
+func (c *Calendar) Session(t time.Time) (open, close time.Time, ok bool) {
+	if !c.TradingDay(t) {
+		return time.Time{}, time.Time{}, false
+	}
+	day := midnight(t)
+	offset := t.Sub(day)
+	for _, s := range Sessions {
+		if offset >= s.PreOpen && offset < s.Close {
+			return day.Add(s.Open), day.Add(s.Close), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// Midnight in Kuala Lumpur on t's date there
+func (c *Calendar) Day(t time.Time) time.Time {
+	return midnight(t)
+}
+
+// Start of the first pre-open after t
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	day := midnight(t)
+	for {
+		open := day.Add(Schedule[0].Start)
+		if open.After(t) && c.TradingDay(open) {
+			return open
+		}
+		y, m, d := day.Date()
+		day = time.Date(y, m, d+1, 0, 0, 0, 0, Location)
+	}
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.In(Location).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, Location)
+}
@@ -0,0 +1,67 @@
+package session_test
+
+import (
+	"bursa-alert/lib/session"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 6, day, hour, minute, 0, 0, session.Location)
+}
+
+func TestPhase(t *testing.T) {
+	c := session.New()
+	for _, tc := range []struct {
+		t     time.Time
+		phase session.Phase
+	}{
+		{at(3, 8, 29), session.PhaseClosed},
+		{at(3, 8, 30), session.PhasePreOpen},
+		{at(3, 9, 0), session.PhaseMorning},
+		{at(3, 12, 30), session.PhaseLunch},
+		{at(3, 14, 0), session.PhasePreOpenAfternoon},
+		{at(3, 14, 30), session.PhaseAfternoon},
+		{at(3, 16, 45), session.PhasePreClose},
+		{at(3, 16, 50), session.PhaseTradingAtLast},
+		{at(3, 17, 0), session.PhaseClosed},
+		// Saturday
+		{at(8, 10, 0), session.PhaseClosed},
+		// Same instant in UTC is 10:00 in Kuala Lumpur
+		{time.Date(2024, 6, 3, 2, 0, 0, 0, time.UTC), session.PhaseMorning},
+	} {
+		if phase := c.Phase(tc.t); phase != tc.phase {
+			t.Errorf("at %s expected %s, got %s", tc.t, tc.phase, phase)
+		}
+	}
+}
+
+func TestHolidays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.yaml")
+	if err := os.WriteFile(path, []byte("holidays:\n  - date: 2024-06-17\n    name: Hari Raya Haji\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := session.New()
+	if err := c.LoadHolidays(path); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := c.Holiday(at(17, 10, 0)); !ok || name != "Hari Raya Haji" {
+		t.Errorf("holiday not loaded: %q", name)
+	}
+	if phase := c.Phase(at(17, 10, 0)); phase != session.PhaseClosed {
+		t.Errorf("market open on a holiday: %s", phase)
+	}
+	// Friday evening skips the weekend and the holiday
+	if open := c.NextOpen(at(14, 18, 0)); !open.Equal(at(18, 8, 30)) {
+		t.Errorf("unexpected next open %s", open)
+	}
+
+	if err := os.WriteFile(path, []byte("holidays:\n  - date: 17/06/2024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadHolidays(path); err == nil {
+		t.Error("malformed date accepted")
+	}
+}
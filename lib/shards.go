@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib/models"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+)
+
+// Spreads stock ids over supervised upstream connections, at most Cap per
+// connection. Every id belongs to exactly one shard, and entries for an id
+// are only delivered by the shard that owns it
+type ShardManager struct {
+	// Most stock ids on one connection
+	Cap        int
+	Options    []ws.OptionModifier
+	Supervisor *Supervisor
+
+	mu      sync.Mutex
+	ids     []uint
+	shards  []*shard
+	ctx     context.Context
+	ch      chan<- models.StockEntry
+	running sync.WaitGroup
+}
+
+type shard struct {
+	sub    *Subscription
+	cancel context.CancelFunc
+	// Ids assigned to the shard, guarded by the manager's mu. The
+	// subscription catches up with them in sync
+	want []uint
+	// Serialises syncs, so the last one sends the latest ids
+	syncing sync.Mutex
+}
+
+func NewShardManager(size int, options ...ws.OptionModifier) *ShardManager {
+	return &ShardManager{Cap: size, Options: options, Supervisor: NewSupervisor()}
+}
+
+// Places ids into shards of at most size ids, starting from the current
+// shards. Ids keep the shard they are in, and only ids not yet placed are
+// added, each to the least filled shard with room, with new shards opened
+// once every shard is full. The result lines up with current, so a shard
+// whose ids were all removed is returned empty
+func AssignShards(current [][]uint, ids []uint, size int) [][]uint {
+	ids = normalise(ids)
+	size = max(size, 1)
+	wanted := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	shards := make([][]uint, len(current))
+	for i, shard := range current {
+		for _, id := range shard {
+			// Ids over the size are placed again below
+			if wanted[id] && len(shards[i]) < size {
+				shards[i] = append(shards[i], id)
+				delete(wanted, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		if !wanted[id] {
+			continue
+		}
+		least := -1
+		for i, shard := range shards {
+			if len(shard) < size && (least < 0 || len(shard) < len(shards[least])) {
+				least = i
+			}
+		}
+		if least < 0 {
+			shards = append(shards, nil)
+			least = len(shards) - 1
+		}
+		shards[least] = append(shards[least], id)
+	}
+	for _, shard := range shards {
+		slices.Sort(shard)
+	}
+	return shards
+}
+
+// Ids across all shards, sorted
+func (m *ShardManager) Ids() []uint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.ids)
+}
+
+// Replaces the ids to stream and rebalances the shards
+func (m *ShardManager) Set(ids []uint) {
+	m.mu.Lock()
+	m.ids = normalise(ids)
+	changed := m.rebalance()
+	m.mu.Unlock()
+	m.sync(changed)
+}
+
+func (m *ShardManager) Add(ids ...uint) {
+	m.mu.Lock()
+	m.ids = normalise(append(slices.Clone(m.ids), ids...))
+	changed := m.rebalance()
+	m.mu.Unlock()
+	m.sync(changed)
+}
+
+func (m *ShardManager) Remove(ids ...uint) {
+	m.mu.Lock()
+	m.ids = slices.DeleteFunc(slices.Clone(m.ids), func(id uint) bool {
+		return slices.Contains(ids, id)
+	})
+	changed := m.rebalance()
+	m.mu.Unlock()
+	m.sync(changed)
+}
+
+// Streams every shard to ch until ctx is cancelled. Dropped connections are
+// restored by the supervisor with their shard's current ids
+func (m *ShardManager) Run(ctx context.Context, ch chan<- models.StockEntry) error {
+	m.mu.Lock()
+	if m.ctx != nil {
+		m.mu.Unlock()
+		return errors.New("shard manager is already running")
+	}
+	m.ctx, m.ch = ctx, ch
+	changed := m.rebalance()
+	m.mu.Unlock()
+	m.sync(changed)
+
+	<-ctx.Done()
+	m.running.Wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ctx, m.ch, m.shards = nil, nil, nil
+	return nil
+}
+
+// Moves the shards to the assignment for the current ids, starting
+// connections for new shards. Returns the existing shards whose ids changed,
+// those losing ids first, for sync to update once m.mu is released. Must
+// hold m.mu
+func (m *ShardManager) rebalance() []*shard {
+	if m.ctx == nil || m.ctx.Err() != nil {
+		return nil
+	}
+	current := make([][]uint, len(m.shards))
+	for i, sh := range m.shards {
+		current[i] = sh.want
+	}
+	assigned := AssignShards(current, m.ids, m.Cap)
+	// Shrink existing subscriptions before growing others, so an id moving
+	// between shards is never owned by two at once
+	var shrunk, grown []*shard
+	shards := make([]*shard, 0, len(assigned))
+	for i, sh := range m.shards {
+		lost := slices.ContainsFunc(sh.want, func(id uint) bool {
+			return !slices.Contains(assigned[i], id)
+		})
+		changed := !slices.Equal(sh.want, assigned[i])
+		sh.want = assigned[i]
+		if lost {
+			shrunk = append(shrunk, sh)
+		} else if changed {
+			grown = append(grown, sh)
+		}
+		if len(sh.want) > 0 {
+			shards = append(shards, sh)
+		}
+	}
+	for _, ids := range assigned[len(m.shards):] {
+		ctx, cancel := context.WithCancel(m.ctx)
+		sh := &shard{sub: NewSubscription(ids...), cancel: cancel, want: ids}
+		shards = append(shards, sh)
+		m.running.Add(1)
+		go func(ch chan<- models.StockEntry) {
+			defer m.running.Done()
+			_ = m.Supervisor.Run(ctx, sh.sub, ch, m.Options...)
+		}(m.ch)
+	}
+	m.shards = shards
+	return append(shrunk, grown...)
+}
+
+// Sends the ids assigned to each shard upstream, closing shards left empty.
+// Must not hold m.mu, so a slow upstream does not hold up other changes
+func (m *ShardManager) sync(shards []*shard) {
+	for _, sh := range shards {
+		sh.syncing.Lock()
+		m.mu.Lock()
+		ids := sh.want
+		m.mu.Unlock()
+		if err := sh.sub.Set(ids); err != nil {
+			log.Printf("Failed to update shard subscription: %s", err)
+		}
+		if len(ids) == 0 {
+			sh.cancel()
+		}
+		sh.syncing.Unlock()
+	}
+}
+
+func (m *ShardManager) Status() []ShardStatus {
+	return m.Supervisor.Status()
+}
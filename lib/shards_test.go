@@ -0,0 +1,101 @@
+package lib_test
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/internal/ws/wstest"
+	"bursa-alert/lib"
+	"bursa-alert/lib/models"
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAssignShards(t *testing.T) {
+	shards := lib.AssignShards(nil, []uint{5, 1, 4, 2, 3, 1}, 2)
+	if want := [][]uint{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("expected %v, got %v", want, shards)
+	}
+	if shards := lib.AssignShards(nil, nil, 2); len(shards) != 0 {
+		t.Errorf("expected no shards, got %v", shards)
+	}
+
+	// Existing ids stay put and new ones fill the emptiest shard first
+	shards = lib.AssignShards([][]uint{{1, 2}, {3, 4}, {5}}, []uint{0, 1, 2, 3, 4, 5}, 2)
+	if want := [][]uint{{1, 2}, {3, 4}, {0, 5}}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("expected %v, got %v", want, shards)
+	}
+	shards = lib.AssignShards([][]uint{{1, 2}, {3, 4}}, []uint{1, 3, 4, 6, 7, 8}, 2)
+	if want := [][]uint{{1, 6}, {3, 4}, {7, 8}}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("expected %v, got %v", want, shards)
+	}
+
+	// Emptied shards keep their place
+	shards = lib.AssignShards([][]uint{{1, 2}, {3, 4}}, []uint{3, 4}, 2)
+	if want := [][]uint{nil, {3, 4}}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("expected %v, got %v", want, shards)
+	}
+}
+
+// Waits until the server's sessions are subscribed to want, in any order
+func waitForShards(t *testing.T, sessions []*wstest.Session, want [][]uint) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	var got [][]uint
+	for time.Now().Before(deadline) {
+		got = got[:0]
+		for _, session := range sessions {
+			if ids := session.Subscribed(); len(ids) > 0 {
+				got = append(got, ids)
+			}
+		}
+		slices.SortFunc(got, func(a, b []uint) int { return int(a[0]) - int(b[0]) })
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected shards %v, got %v", want, got)
+}
+
+func TestShardManager(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	m := lib.NewShardManager(2, ws.WithURL(server.URL()))
+	m.Set([]uint{1, 2, 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan models.StockEntry, 10)
+	go m.Run(ctx, ch)
+
+	sessions := []*wstest.Session{server.Session(time.Second), server.Session(time.Second)}
+	waitForShards(t, sessions, [][]uint{{1, 2}, {3}})
+
+	// Growing past the cap fills the open shard, then opens another
+	// connection, without moving any id
+	m.Add(4, 5)
+	sessions = append(sessions, server.Session(time.Second))
+	waitForShards(t, sessions, [][]uint{{1, 2}, {3, 4}, {5}})
+
+	// Only the owning shard delivers an id
+	for _, session := range sessions {
+		if err := session.Send("MT", map[string]any{"1": 3, "209": 1000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := <-ch; e.GetIndex() != 3 {
+		t.Errorf("unexpected entry %v", e.ToMap())
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("duplicate entry %v", e.ToMap())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.Remove(1, 2, 3)
+	waitForShards(t, sessions, [][]uint{{4}, {5}})
+	if ids := m.Ids(); !slices.Equal(ids, []uint{4, 5}) {
+		t.Errorf("manager tracks %v", ids)
+	}
+}
@@ -0,0 +1,44 @@
+package source
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib"
+	"bursa-alert/lib/models"
+	"context"
+)
+
+// The live Maybank cyberstock feed
+type Cyberstock struct {
+	Options []ws.OptionModifier
+	// Streamed ids can be changed through Shards while Stream runs
+	Shards *lib.ShardManager
+}
+
+func NewCyberstock(options ...ws.OptionModifier) *Cyberstock {
+	return &Cyberstock{Options: options, Shards: lib.NewShardManager(50, options...)}
+}
+
+func (c *Cyberstock) Metadata(ctx context.Context) (map[uint]models.StockMetadata, error) {
+	m := make(map[uint]models.StockMetadata)
+	if err := lib.GetStockMetadata(ctx, m, c.Options...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *Cyberstock) Stream(ctx context.Context, ids []uint, ch chan<- models.StockEntry) error {
+	c.Shards.Set(ids)
+	return c.Shards.Run(ctx, ch)
+}
+
+func (c *Cyberstock) Status() []lib.ShardStatus {
+	return c.Shards.Status()
+}
+
+func (c *Cyberstock) Subscribe(ids ...uint) {
+	c.Shards.Add(ids...)
+}
+
+func (c *Cyberstock) Unsubscribe(ids ...uint) {
+	c.Shards.Remove(ids...)
+}
@@ -0,0 +1,119 @@
+package source
+
+import (
+	"bursa-alert/lib"
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Keeps global.Metadata in step with a source, refreshing on an interval and
+// when the market opens. Listings and delistings are subscribed to and
+// dropped if the source is a Resubscriber
+type Refresher struct {
+	Source   DataSource
+	Interval time.Duration
+	// Decides when the market opens
+	Calendar *session.Calendar
+	// Between attempts after a failed refresh
+	Backoff lib.Backoff
+	// Called with the metadata after every successful refresh
+	AfterRefresh func(stocks map[uint]models.StockMetadata, at time.Time)
+
+	refreshed bool
+}
+
+func NewRefresher(src DataSource) *Refresher {
+	return &Refresher{Source: src, Interval: time.Hour, Calendar: session.Default, Backoff: lib.DefaultBackoff}
+}
+
+// Fetches the source's metadata and applies it to global.Metadata. The first
+// metadata loaded is taken as is, without reporting every stock as listed
+func (r *Refresher) Refresh(ctx context.Context) ([]models.MetadataEvent, error) {
+	m, err := r.Source.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// An empty list is a failed fetch, not every stock being delisted
+	if len(m) == 0 {
+		return nil, errors.New("source returned no metadata")
+	}
+	r.refreshed = true
+	now := time.Now()
+	if r.AfterRefresh != nil {
+		defer r.AfterRefresh(m, now)
+	}
+	if global.Metadata.Updated().IsZero() {
+		global.Metadata.Load(m, now)
+		return nil, nil
+	}
+	events := global.Metadata.Update(m, now)
+	if sub, ok := r.Source.(Resubscriber); ok {
+		var listed, delisted []uint
+		for _, e := range events {
+			switch e.Kind {
+			case models.EventListing:
+				listed = append(listed, e.Id)
+			case models.EventDelisting:
+				delisted = append(delisted, e.Id)
+			}
+		}
+		if len(listed) > 0 {
+			sub.Subscribe(listed...)
+		}
+		if len(delisted) > 0 {
+			sub.Unsubscribe(delisted...)
+		}
+	}
+	return events, nil
+}
+
+// Time of the refresh following one at now
+func (r *Refresher) Next(now time.Time) time.Time {
+	next := now.Add(r.Interval)
+	if open := r.Calendar.NextOpen(now); open.Before(next) {
+		return open
+	}
+	return next
+}
+
+// Refreshes until ctx is cancelled, sending every change to ch. Unless
+// Refresh has already succeeded, the first refresh is immediate so metadata
+// loaded from elsewhere is reconciled with the source
+func (r *Refresher) Run(ctx context.Context, ch chan<- models.MetadataEvent) {
+	next := time.Now()
+	if r.refreshed {
+		next = r.Next(next)
+	}
+	failures := 0
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		events, err := r.Refresh(ctx)
+		if err != nil {
+			failures++
+			delay := r.Backoff.Delay(failures)
+			log.Printf("Failed to refresh metadata: %s, retrying in %s", err, delay.Round(time.Millisecond))
+			next = time.Now().Add(delay)
+			continue
+		}
+		failures = 0
+		next = r.Next(time.Now())
+		for _, e := range events {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package source_test
+
+import (
+	"bursa-alert/lib/global"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"bursa-alert/lib/source"
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// A source whose metadata is set by the test
+type listings struct {
+	stocks       map[uint]models.StockMetadata
+	subscribed   []uint
+	unsubscribed []uint
+}
+
+func (l *listings) Metadata(context.Context) (map[uint]models.StockMetadata, error) {
+	return l.stocks, nil
+}
+
+func (l *listings) Stream(context.Context, []uint, chan<- models.StockEntry) error {
+	return nil
+}
+
+func (l *listings) Subscribe(ids ...uint) {
+	l.subscribed = append(l.subscribed, ids...)
+}
+
+func (l *listings) Unsubscribe(ids ...uint) {
+	l.unsubscribed = append(l.unsubscribed, ids...)
+}
+
+func TestRefresh(t *testing.T) {
+	global.Metadata.Reset()
+	defer global.Metadata.Reset()
+	global.Metadata.Load(map[uint]models.StockMetadata{
+		1: {Name: "MAYBANK", Ticker: "1155", Id: 1},
+		2: {Name: "OLDNAME", Ticker: "5000", Id: 2},
+		3: {Name: "GONE", Ticker: "7000", Id: 3},
+	}, time.Now())
+	src := &listings{stocks: map[uint]models.StockMetadata{
+		1: {Name: "MAYBANK", Ticker: "1155", Id: 1},
+		2: {Name: "NEWNAME", Ticker: "5001", Id: 2},
+		4: {Name: "NEW", Ticker: "0300", Id: 4},
+	}}
+	events, err := source.NewRefresher(src).Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []models.MetadataEventKind
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []models.MetadataEventKind{models.EventNameChange, models.EventTickerChange, models.EventDelisting, models.EventListing}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("expected %v, got %v", want, kinds)
+	}
+	if !slices.Equal(src.subscribed, []uint{4}) || !slices.Equal(src.unsubscribed, []uint{3}) {
+		t.Errorf("subscribed %v, unsubscribed %v", src.subscribed, src.unsubscribed)
+	}
+	if m, _ := global.Metadata.Get(2); m.Name != "NEWNAME" {
+		t.Errorf("metadata not updated: %+v", m)
+	}
+
+	// A failed fetch must not delist everything
+	src.stocks = nil
+	if _, err := source.NewRefresher(src).Refresh(context.Background()); err == nil {
+		t.Error("empty metadata accepted")
+	}
+	if ids := global.Metadata.Ids(); !slices.Equal(ids, []uint{1, 2, 4}) {
+		t.Errorf("metadata changed to %v", ids)
+	}
+}
+
+func TestRefreshSchedule(t *testing.T) {
+	myt := session.Location
+	r := source.NewRefresher(nil)
+	r.Calendar = session.New()
+	r.Calendar.AddHolidays(session.Holiday{Date: "2024-06-17", Name: "Hari Raya Haji"})
+	for _, c := range []struct{ now, next time.Time }{
+		// Market open is sooner than the interval
+		{time.Date(2024, 6, 3, 8, 0, 0, 0, myt), time.Date(2024, 6, 3, 8, 30, 0, 0, myt)},
+		{time.Date(2024, 6, 3, 10, 0, 0, 0, myt), time.Date(2024, 6, 3, 11, 0, 0, 0, myt)},
+		// Weekends and holidays skip to the interval
+		{time.Date(2024, 6, 8, 8, 0, 0, 0, myt), time.Date(2024, 6, 8, 9, 0, 0, 0, myt)},
+		{time.Date(2024, 6, 17, 8, 0, 0, 0, myt), time.Date(2024, 6, 17, 9, 0, 0, 0, myt)},
+	} {
+		if next := r.Next(c.now); !next.Equal(c.next) {
+			t.Errorf("after %s expected %s, got %s", c.now, c.next, next)
+		}
+	}
+}
+
+func TestRefreshFirstLoad(t *testing.T) {
+	global.Metadata.Reset()
+	defer global.Metadata.Reset()
+	src := &listings{stocks: map[uint]models.StockMetadata{1: {Name: "MAYBANK", Ticker: "1155", Id: 1}}}
+	r := source.NewRefresher(src)
+	var saved map[uint]models.StockMetadata
+	r.AfterRefresh = func(stocks map[uint]models.StockMetadata, _ time.Time) {
+		saved = stocks
+	}
+	events, err := r.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || len(src.subscribed) != 0 {
+		t.Errorf("first load reported %+v", events)
+	}
+	if !reflect.DeepEqual(saved, src.stocks) || global.Metadata.Updated().IsZero() {
+		t.Errorf("refresh not saved: %v", saved)
+	}
+}
@@ -0,0 +1,32 @@
+// Market data feeds the alert engine can run against
+package source
+
+import (
+	"bursa-alert/lib"
+	"bursa-alert/lib/models"
+	"context"
+	"time"
+)
+
+type DataSource interface {
+	// Metadata of every stock the source can stream, keyed by stock id
+	Metadata(ctx context.Context) (map[uint]models.StockMetadata, error)
+	// Sends full entries for ids to ch until ctx is cancelled or the source runs out
+	Stream(ctx context.Context, ids []uint, ch chan<- models.StockEntry) error
+}
+
+// Implemented by sources that run on their own clock, such as replays
+type Clock interface {
+	Now() time.Time
+}
+
+// Implemented by sources backed by supervised upstream connections
+type StatusReporter interface {
+	Status() []lib.ShardStatus
+}
+
+// Implemented by sources whose streamed ids can change while streaming
+type Resubscriber interface {
+	Subscribe(ids ...uint)
+	Unsubscribe(ids ...uint)
+}
@@ -0,0 +1,58 @@
+package source_test
+
+import (
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/source"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyntheticStream(t *testing.T) {
+	synthetic := source.NewSynthetic(50, 7)
+	synthetic.Interval = time.Millisecond
+	var src source.DataSource = synthetic
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := src.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 50 {
+		t.Fatalf("expected 50 stocks, got %d", len(m))
+	}
+	ids := make([]uint, 0, len(m))
+	for id, stock := range m {
+		if stock.Id != int(id) {
+			t.Errorf("stock %d has id %d", id, stock.Id)
+		}
+		ids = append(ids, id)
+	}
+
+	ch := make(chan models.StockEntry)
+	done := make(chan error, 1)
+	go func() {
+		done <- src.Stream(ctx, ids, ch)
+	}()
+	for i := 0; i < 20; i++ {
+		select {
+		case e := <-ch:
+			if _, ok := m[e.GetIndex()]; !ok {
+				t.Fatalf("entry for unknown stock %d", e.GetIndex())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no entries arrived")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stream did not return after cancel")
+	}
+}
@@ -0,0 +1,191 @@
+package source
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Generates a Bursa-like market. The same seed always yields the same
+// metadata and, for the same sequence of Step times, the same entries
+type Synthetic struct {
+	Stocks int
+	Seed   uint64
+	// Time between steps in Stream
+	Interval time.Duration
+
+	once   sync.Once
+	mu     sync.Mutex
+	stocks map[uint]*synthStock
+}
+
+type synthStock struct {
+	rng   *rand.Rand
+	entry internal.StockEntry
+	// Chance of a trade on each step
+	activity float64
+	// Remaining steps of a burst of activity
+	burst int
+}
+
+func NewSynthetic(stocks int, seed uint64) *Synthetic {
+	return &Synthetic{Stocks: stocks, Seed: seed, Interval: 200 * time.Millisecond}
+}
+
+func (s *Synthetic) init() {
+	s.stocks = make(map[uint]*synthStock, s.Stocks)
+	for id := uint(0); id < uint(s.Stocks); id++ {
+		rng := rand.New(rand.NewPCG(s.Seed, uint64(id)+1))
+		// Log uniform between 5 sen and RM50 so penny stocks dominate like on Bursa
+		preclose := synthRound(uint(math.Exp(rng.Float64()*math.Log(1000)) * 50))
+		s.stocks[id] = &synthStock{
+			rng: rng,
+			entry: internal.StockEntry{
+				StockIndex:    id,
+				LastPrice:     preclose,
+				PreclosePrice: preclose,
+			},
+			activity: 0.01 + rng.Float64()*0.2,
+		}
+	}
+}
+
+func (s *Synthetic) Metadata(_ context.Context) (map[uint]models.StockMetadata, error) {
+	rng := rand.New(rand.NewPCG(s.Seed, 0))
+	m := make(map[uint]models.StockMetadata, s.Stocks)
+	seen := make(map[string]bool, s.Stocks)
+	for id := 0; id < s.Stocks; id++ {
+		ticker := synthWord(rng, 3+rng.IntN(5))
+		for seen[ticker] {
+			ticker = fmt.Sprintf("%s%d", ticker[:len(ticker)-1], rng.IntN(10))
+		}
+		seen[ticker] = true
+		m[uint(id)] = models.StockMetadata{
+			Name:   fmt.Sprintf("%s %s BHD", ticker, synthWord(rng, 4+rng.IntN(6))),
+			Ticker: ticker,
+			Id:     id,
+		}
+	}
+	return m, nil
+}
+
+func (s *Synthetic) Stream(ctx context.Context, ids []uint, ch chan<- models.StockEntry) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t := <-ticker.C:
+			for _, entry := range s.Step(t, ids) {
+				select {
+				case ch <- entry:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// Advances the market by one step at t and returns the entries of the stocks
+// in ids that traded, in id order
+func (s *Synthetic) Step(t time.Time, ids []uint) []models.StockEntry {
+	s.once.Do(s.init)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	local := t.In(session.Location)
+	minutes := local.Hour()*60 + local.Minute()
+	// Activity picks up around the morning and afternoon opens
+	boost := 1.0
+	if (minutes >= 9*60 && minutes < 9*60+30) || (minutes >= 14*60+30 && minutes < 15*60) {
+		boost = 3
+	}
+	afternoon := minutes >= 12*60+30
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	var entries []models.StockEntry
+	for _, id := range sorted {
+		stock, ok := s.stocks[id]
+		if !ok {
+			continue
+		}
+		if stock.step(boost, afternoon) {
+			entries = append(entries, models.NewStockEntry(stock.entry))
+		}
+	}
+	return entries
+}
+
+// Returns whether the stock traded
+func (st *synthStock) step(boost float64, afternoon bool) bool {
+	rng := st.rng
+	activity, spread := st.activity*boost, 1
+	if st.burst > 0 {
+		st.burst--
+		activity, spread = min(activity*10, 0.9), 3
+	} else if rng.Float64() < 0.0005 {
+		st.burst = 20 + rng.IntN(80)
+	}
+	if rng.Float64() >= activity {
+		st.entry.IsPurchase = 0
+		st.entry.TotalBoughtQuantity = 0
+		return false
+	}
+
+	// Random walk in whole ticks
+	e := &st.entry
+	ticks := rng.IntN(2*spread+1) - spread
+	for ; ticks > 0; ticks-- {
+		e.LastPrice = uint(models.Ticks.Next(models.Price(e.LastPrice)))
+	}
+	for ; ticks < 0 && models.Ticks.Prev(models.Price(e.LastPrice)) > 0; ticks++ {
+		e.LastPrice = uint(models.Ticks.Prev(models.Price(e.LastPrice)))
+	}
+
+	// Lots of 100 shares, heavy tailed
+	lots := uint(1 + rng.ExpFloat64()*float64(20*spread))
+	buy := rng.Float64() < 0.5
+	e.IsPurchase = 0
+	e.TotalBoughtQuantity = 0
+	switch {
+	case buy && afternoon:
+		e.BuyVolumeAfternoon += lots
+	case buy:
+		e.BuyVolumeMorning += lots
+	case afternoon:
+		e.SellVolumeAfternoon += lots
+	default:
+		e.SellVolumeMorning += lots
+	}
+	if buy {
+		e.IsPurchase = 1
+		e.TotalBoughtQuantity = lots
+		e.BuyValue += e.LastPrice * lots * 100
+	}
+	e.AccumulatedValue += float32(e.LastPrice) / 1000 * float32(lots*100)
+	return true
+}
+
+// Tick size in milicents for prices from p upwards
+// Rounds down to a valid price, at least one tick
+func synthRound(p uint) uint {
+	t := uint(models.Ticks.Size(models.Price(p)))
+	return max(p-p%t, t)
+}
+
+func synthWord(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('A' + rng.IntN(26))
+	}
+	return string(b)
+}
@@ -0,0 +1,54 @@
+package source_test
+
+import (
+	"bursa-alert/lib/source"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyntheticDeterministic(t *testing.T) {
+	ctx := context.Background()
+	a, b := source.NewSynthetic(300, 42), source.NewSynthetic(300, 42)
+	ma, _ := a.Metadata(ctx)
+	mb, _ := b.Metadata(ctx)
+	if len(ma) != 300 || !reflect.DeepEqual(ma, mb) {
+		t.Fatal("metadata differs between runs")
+	}
+	tickers := make(map[string]bool)
+	for _, m := range ma {
+		tickers[m.Ticker] = true
+	}
+	if len(tickers) != 300 {
+		t.Errorf("tickers are not unique")
+	}
+
+	ids := make([]uint, 300)
+	for i := range ids {
+		ids[i] = uint(i)
+	}
+	start := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	traded := 0
+	for i := 0; i < 500; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		ea, eb := a.Step(now, ids), b.Step(now, ids)
+		if !reflect.DeepEqual(ea, eb) {
+			t.Fatalf("step %d differs between runs", i)
+		}
+		traded += len(ea)
+		for _, e := range ea {
+			// Valid Bursa ticks
+			p := int(e.GetLastPrice())
+			if (p < 1000 && p%5 != 0) || (p >= 1000 && p < 10000 && p%10 != 0) || p <= 0 {
+				t.Fatalf("price %d is not on a tick", p)
+			}
+		}
+	}
+	if traded == 0 {
+		t.Error("no trades generated")
+	}
+	if c := source.NewSynthetic(300, 43); reflect.DeepEqual(c.Step(start, ids), source.NewSynthetic(300, 42).Step(start, ids)) {
+		t.Error("different seeds produce the same market")
+	}
+}
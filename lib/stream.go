@@ -1,56 +1,75 @@
 package lib
 
 import (
-	"bursa-alert/internal"
 	"bursa-alert/internal/ws"
+	"bursa-alert/lib/global"
 	"bursa-alert/lib/handlers"
 	"bursa-alert/lib/models"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"sync"
 )
 
-func GetDataStream(ctx context.Context, subscriptions []uint, ch chan models.StockEntry, options ...ws.OptionModifier) error {
-	if options == nil {
-		options = make([]ws.OptionModifier, 0)
+// Streams the subscription to ch over one connection, reconnecting with backoff
+// until ctx is cancelled. Ids can be added to or removed from sub while streaming
+func GetDataStream(ctx context.Context, sub *Subscription, ch chan<- models.StockEntry, options ...ws.OptionModifier) error {
+	return NewSupervisor().Run(ctx, sub, ch, options...)
+}
+
+// Fills m with the SL pages sent during a handshake. SL carries no page
+// count, so the pages are checked to cover the ids from 0 without gaps, and m
+// is only written to when they do. A missing last page cannot be detected
+func GetStockMetadata(ctx context.Context, m map[uint]models.StockMetadata, options ...ws.OptionModifier) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	pages := make(map[uint]models.StockMetadata)
+	options = append(options, ws.WithMessageHandler("SL", handlers.GetStockMapping(pages, &mu)))
+	conn, err := ws.NewConnection(ctx, []uint{}, options...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pages) == 0 {
+		return errors.New("no stocks were listed")
 	}
-	for {
-		newCtx, cancel := context.WithCancel(ctx)
-		select {
-		case <-ctx.Done():
-			cancel()
-			return nil
-		default:
-			conn, err := ws.NewConnection(newCtx, subscriptions, options...)
-			if err != nil {
-				cancel()
-				log.Println(err)
-				continue
+	for id := range pages {
+		if id >= uint(len(pages)) {
+			return fmt.Errorf("stock list has gaps: %d stocks listed up to id %d", len(pages), id)
+		}
+	}
+	maps.Copy(m, pages)
+	return nil
+}
+
+// Merges MT/SM frames into the stock snapshots and sends the result to ch
+func StockHandler(mt string, ch chan<- models.StockEntry) ws.MessageHandler {
+	return stockHandler(mt, ch, nil)
+}
+
+// Like StockHandler, ignoring frames for stocks keep rejects
+func stockHandler(mt string, ch chan<- models.StockEntry, keep func(uint) bool) ws.MessageHandler {
+	return func(_ *ws.Connection, b []byte) error {
+		if keep != nil {
+			var head struct {
+				StockIndex *uint `json:"1"`
 			}
-			stockHandler := func(_ *ws.Connection, b []byte) error {
-				var internalStockEntry internal.StockEntry
-				if err := json.Unmarshal(b, &internalStockEntry); err != nil {
-					log.Println(err)
-					return err
-				}
-				ch <- models.NewStockEntry(internalStockEntry)
+			if json.Unmarshal(b, &head) == nil && head.StockIndex != nil && !keep(*head.StockIndex) {
 				return nil
 			}
-			conn.AddHandler("MT", stockHandler)
-			conn.AddHandler("SM", stockHandler)
-			if err := conn.StartReadLoop(); err != nil {
-				cancel()
-				log.Println(err)
-				continue
-			}
-			cancel()
 		}
+		entry, err := global.Snapshots.Merge(mt, b)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		ch <- entry
+		return nil
 	}
 }
-
-func GetStockMetadata(m map[uint]models.StockMetadata) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	_, err := ws.NewConnection(ctx, []uint{}, ws.WithMessageHandler("SL", handlers.GetStockMapping(m)))
-	return err
-}
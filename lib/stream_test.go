@@ -0,0 +1,66 @@
+package lib_test
+
+import (
+	"bursa-alert/internal"
+	"bursa-alert/internal/ws"
+	"bursa-alert/internal/ws/wstest"
+	"bursa-alert/lib"
+	"bursa-alert/lib/models"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGetStockMetadata(t *testing.T) {
+	server := wstest.NewServer()
+	server.PageSize = 2
+	server.Stocks = []wstest.Stock{
+		{Ticker: "MAYBANK", Name: "MALAYAN BANKING BHD"},
+		{Ticker: "PBBANK", Name: "PUBLIC BANK BHD"},
+		{Ticker: "CIMB", Name: "CIMB GROUP HOLDINGS BHD"},
+	}
+	defer server.Close()
+
+	m := make(map[uint]models.StockMetadata)
+	if err := lib.GetStockMetadata(context.Background(), m, ws.WithURL(server.URL())); err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 {
+		t.Fatalf("expected 3 stocks, got %d", len(m))
+	}
+	if m[2].Ticker != "CIMB" || m[2].Id != 2 {
+		t.Errorf("unexpected metadata %+v", m[2])
+	}
+
+	// The connection is not left open
+	select {
+	case <-server.Session(time.Second).Done():
+	case <-time.After(time.Second):
+		t.Error("connection was not closed")
+	}
+}
+
+func TestGetStockMetadataGaps(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	// The first page is lost
+	server.Handle("SS", func(ss *wstest.Session, _ json.RawMessage) error {
+		if err := ss.Reply("SL", internal.Encode(map[int]any{
+			internal.FieldNames:   []string{"CIMB GROUP HOLDINGS BHD"},
+			internal.FieldTickers: []string{"CIMB"},
+			internal.FieldOffset:  2,
+		})); err != nil {
+			return err
+		}
+		return ss.Reply("SU", map[string]any{})
+	})
+
+	m := make(map[uint]models.StockMetadata)
+	if err := lib.GetStockMetadata(context.Background(), m, ws.WithURL(server.URL())); err == nil {
+		t.Error("accepted a stock list with gaps")
+	}
+	if len(m) != 0 {
+		t.Errorf("filled metadata from a partial list: %v", m)
+	}
+}
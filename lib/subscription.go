@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"bursa-alert/internal/ws"
+	"slices"
+	"sync"
+)
+
+// Stock ids wanted on a supervised connection. Changes are sent to the live
+// connection straight away and are restored after reconnects
+type Subscription struct {
+	mu   sync.Mutex
+	ids  []uint
+	conn *ws.Connection
+}
+
+func NewSubscription(ids ...uint) *Subscription {
+	return &Subscription{ids: normalise(ids)}
+}
+
+func normalise(ids []uint) []uint {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// Current ids, sorted
+func (s *Subscription) Ids() []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.ids)
+}
+
+func (s *Subscription) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ids)
+}
+
+// Whether entries for id should be delivered
+func (s *Subscription) Contains(id uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := slices.BinarySearch(s.ids, id)
+	return ok
+}
+
+func (s *Subscription) Set(ids []uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids = normalise(ids)
+	if slices.Equal(ids, s.ids) {
+		return nil
+	}
+	s.ids = ids
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Subscribe(s.ids)
+}
+
+func (s *Subscription) Add(ids ...uint) error {
+	return s.Set(append(s.Ids(), ids...))
+}
+
+func (s *Subscription) Remove(ids ...uint) error {
+	return s.Set(slices.DeleteFunc(s.Ids(), func(id uint) bool {
+		return slices.Contains(ids, id)
+	}))
+}
+
+// Makes conn the live connection, catching it up on changes made while it was connecting
+func (s *Subscription) attach(conn *ws.Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	if slices.Equal(conn.Subscriptions(), s.ids) {
+		return nil
+	}
+	return conn.Subscribe(s.ids)
+}
+
+func (s *Subscription) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = nil
+}
@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib/models"
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+type ConnState string
+
+const (
+	StateConnecting  ConnState = "connecting"
+	StateLoggingIn   ConnState = "logging_in"
+	StateSubscribed  ConnState = "subscribed"
+	StateStreaming   ConnState = "streaming"
+	StateBackoff     ConnState = "backoff"
+	StateCircuitOpen ConnState = "circuit_open"
+)
+
+var errPingTimeout = errors.New("no ping from upstream")
+
+// Exponential backoff with jitter
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Fraction of the delay that is randomised, 0 to 1
+	Jitter float64
+}
+
+var DefaultBackoff = Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 0.5}
+
+// Delay before the retry following the given number of consecutive failures
+func (b Backoff) Delay(failures int) time.Duration {
+	d := float64(b.Initial)
+	for i := 1; i < failures && d < float64(b.Max); i++ {
+		d *= b.Multiplier
+	}
+	d = min(d, float64(b.Max))
+	return time.Duration(d * (1 - b.Jitter*rand.Float64()))
+}
+
+type ShardStatus struct {
+	Shard       int       `json:"shard"`
+	State       ConnState `json:"state"`
+	Since       time.Time `json:"since"`
+	Stocks      int       `json:"stocks"`
+	LastMessage time.Time `json:"last_message"`
+	Connects    int       `json:"connects"`
+	// Consecutive failed connections
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	RetryAt   time.Time `json:"retry_at"`
+}
+
+// Keeps upstream connections alive and tracks their health
+type Supervisor struct {
+	Backoff Backoff
+	// Consecutive failures that open the circuit, and how long it stays open
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	mu     sync.Mutex
+	shards map[int]*ShardStatus
+	subs   map[int]*Subscription
+	next   int
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		Backoff:          DefaultBackoff,
+		BreakerThreshold: 5,
+		BreakerCooldown:  5 * time.Minute,
+		shards:           make(map[int]*ShardStatus),
+		subs:             make(map[int]*Subscription),
+	}
+}
+
+// Streams the subscription to ch over one upstream connection, reconnecting
+// until ctx is cancelled
+func (s *Supervisor) Run(ctx context.Context, sub *Subscription, ch chan<- models.StockEntry, options ...ws.OptionModifier) error {
+	shard := s.register(sub)
+	defer s.remove(shard)
+	for {
+		s.set(shard, StateConnecting)
+		err := s.connect(ctx, shard, sub, ch, options)
+		if ctx.Err() != nil {
+			return nil
+		}
+		delay, state := s.fail(shard, err)
+		log.Printf("Shard %d: %s, retrying in %s", shard, err, delay.Round(time.Millisecond))
+		s.set(shard, state)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *Supervisor) connect(ctx context.Context, shard int, sub *Subscription, ch chan<- models.StockEntry, options []ws.OptionModifier) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	options = append(slices.Clone(options),
+		ws.WithStageHook(func(stage ws.Stage) {
+			switch stage {
+			case ws.StageLoggingIn:
+				s.set(shard, StateLoggingIn)
+			case ws.StageSubscribed:
+				s.set(shard, StateSubscribed)
+			}
+		}),
+		ws.WithObserver(func(mt string, _ []byte) {
+			s.touch(shard, mt)
+		}),
+	)
+	conn, err := ws.NewConnection(ctx, sub.Ids(), options...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Frames for ids already moved to another shard are dropped, so no id
+	// is delivered twice
+	conn.AddHandler("MT", stockHandler("MT", ch, sub.Contains))
+	conn.AddHandler("SM", stockHandler("SM", ch, sub.Contains))
+	if err := sub.attach(&conn); err != nil {
+		return err
+	}
+	defer sub.detach()
+	if err := conn.StartReadLoop(); err != nil {
+		return err
+	}
+	// The read loop only ends cleanly when ctx is done or pings stop
+	return errPingTimeout
+}
+
+func (s *Supervisor) register(sub *Subscription) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard := s.next
+	s.next++
+	s.shards[shard] = &ShardStatus{Shard: shard, Since: time.Now()}
+	s.subs[shard] = sub
+	return shard
+}
+
+func (s *Supervisor) remove(shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.shards, shard)
+	delete(s.subs, shard)
+}
+
+func (s *Supervisor) set(shard int, state ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.shards[shard]
+	if status.State == state {
+		return
+	}
+	status.State = state
+	status.Since = time.Now()
+	if state == StateSubscribed {
+		status.Connects++
+	}
+}
+
+// Records an inbound message. The first stock data marks the connection healthy
+func (s *Supervisor) touch(shard int, mt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.shards[shard]
+	status.LastMessage = time.Now()
+	if (mt == "MT" || mt == "SM") && status.State == StateSubscribed {
+		status.State = StateStreaming
+		status.Since = status.LastMessage
+		status.Failures = 0
+		status.LastError = ""
+	}
+}
+
+// Counts a failed connection and picks the delay before the next attempt
+func (s *Supervisor) fail(shard int, err error) (time.Duration, ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.shards[shard]
+	status.Failures++
+	status.LastError = err.Error()
+	delay, state := s.Backoff.Delay(status.Failures), StateBackoff
+	if s.BreakerThreshold > 0 && status.Failures >= s.BreakerThreshold {
+		// Stays open for the whole cooldown. A single failure after it reopens it
+		delay, state = s.BreakerCooldown, StateCircuitOpen
+	}
+	status.RetryAt = time.Now().Add(delay)
+	return delay, state
+}
+
+// Status of every shard, ordered by shard
+func (s *Supervisor) Status() []ShardStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ShardStatus, 0, len(s.shards))
+	for shard, status := range s.shards {
+		status.Stocks = s.subs[shard].Len()
+		out = append(out, *status)
+	}
+	slices.SortFunc(out, func(a, b ShardStatus) int {
+		return a.Shard - b.Shard
+	})
+	return out
+}
@@ -0,0 +1,114 @@
+package lib_test
+
+import (
+	"bursa-alert/internal/ws"
+	"bursa-alert/internal/ws/wstest"
+	"bursa-alert/lib"
+	"bursa-alert/lib/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := lib.Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+	for failures, max := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 6: 10 * time.Second} {
+		if failures == 0 {
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			if d := b.Delay(failures); d > max || d < max/2 {
+				t.Fatalf("delay %s after %d failures is outside [%s, %s]", d, failures, max/2, max)
+			}
+		}
+	}
+}
+
+func waitForState(t *testing.T, s *lib.Supervisor, state lib.ConnState) lib.ShardStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := s.Status(); len(status) == 1 && status[0].State == state {
+			return status[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("shard never reached %s: %+v", state, s.Status())
+	return lib.ShardStatus{}
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	s := lib.NewSupervisor()
+	s.Backoff = lib.Backoff{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond, Multiplier: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan models.StockEntry, 10)
+	sub := lib.NewSubscription(1)
+	go s.Run(ctx, sub, ch, ws.WithURL(server.URL()))
+
+	session := server.Session(time.Second)
+	waitForState(t, s, lib.StateSubscribed)
+	if err := session.Send("MT", map[string]any{"1": 1, "209": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	status := waitForState(t, s, lib.StateStreaming)
+	if status.Connects != 1 || status.LastMessage.IsZero() {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Dropped connections are restored with the subscription as changed since
+	if err := sub.Add(2); err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+	session = server.Session(time.Second)
+	if session == nil {
+		t.Fatal("supervisor did not reconnect")
+	}
+	status = waitForState(t, s, lib.StateSubscribed)
+	if status.Connects != 2 || status.Failures != 1 || status.LastError == "" || status.Stocks != 2 {
+		t.Errorf("unexpected status after reconnect %+v", status)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !slices.Equal(session.Subscribed(), []uint{1, 2}) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ids := session.Subscribed(); !slices.Equal(ids, []uint{1, 2}) {
+		t.Errorf("resubscribed to %v", ids)
+	}
+
+	cancel()
+	deadline = time.Now().Add(time.Second)
+	for len(s.Status()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(s.Status()) != 0 {
+		t.Error("stopped shard still reported")
+	}
+}
+
+func TestSupervisorCircuitBreaker(t *testing.T) {
+	server := wstest.NewServer()
+	defer server.Close()
+	server.Handle("LG", func(*wstest.Session, json.RawMessage) error {
+		return errors.New("login rejected")
+	})
+	s := lib.NewSupervisor()
+	s.Backoff = lib.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}
+	s.BreakerThreshold = 3
+	s.BreakerCooldown = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, lib.NewSubscription(1), make(chan models.StockEntry), ws.WithURL(server.URL()))
+
+	status := waitForState(t, s, lib.StateCircuitOpen)
+	if status.Failures != 3 || time.Until(status.RetryAt) < 59*time.Minute {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
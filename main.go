@@ -1,18 +1,25 @@
 package main
 
 import (
+	"bursa-alert/internal"
 	"bursa-alert/internal/database"
 	"bursa-alert/lib"
 	"bursa-alert/lib/alerts"
 	"bursa-alert/lib/global"
 	"bursa-alert/lib/models"
+	"bursa-alert/lib/replay"
+	"bursa-alert/lib/session"
+	"bursa-alert/lib/source"
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"syscall"
 	"time"
@@ -25,16 +32,48 @@ import (
 //go:embed frontend/*
 var frontend embed.FS
 
-var (
-	notificationsCache = make(map[uint]models.StockEntry)
-	stockMetadata      = make(map[uint]models.StockMetadata)
-)
+var notificationsCache = make(map[uint]models.StockEntry)
+
+// Firing state of alerts that do not notify on every entry
+var triggers = alerts.NewTriggers(session.Default)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		record(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		discover(os.Args[2:])
+		return
+	}
+	loadHolidays()
+	loadTicks()
+	loadBoards()
+	var src source.DataSource
+	var player *replay.Player
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		player = newPlayer(os.Args[2:])
+		src = player
+	} else if len(os.Args) > 1 && os.Args[1] == "synthetic" {
+		src = newSynthetic(os.Args[2:])
+	} else {
+		cyberstock := source.NewCyberstock(upstreamOptions()...)
+		cyberstock.Shards.Cap = shardSize()
+		src = cyberstock
+	}
 	wsMap := make(map[uint]*websocket.Conn)
 	wsIndex := uint(0)
 
+	if lists, err := database.LoadWatchlists(); err == nil {
+		global.Watchlists.Load(lists)
+	}
 	alertList := database.LoadAlerts()
+	// Like metadata, only the live feed's trigger states outlive the process
+	_, live := src.(*source.Cyberstock)
+	if states, err := database.LoadTriggers(); live && err == nil {
+		triggers.Load(states, alertList)
+	}
+	alertStore := alerts.NewStore(alertList, triggers)
 	e := echo.New()
 
 	subFs, _ := fs.Sub(frontend, "frontend")
@@ -42,7 +81,7 @@ func main() {
 	// Alert GET/POST/DELETE
 	alertGroup := e.Group("/alerts")
 	alertGroup.GET("/", func(c echo.Context) error {
-		return c.JSON(200, alertList)
+		return c.JSON(200, alertStore.All())
 	})
 	alertGroup.PUT("/", func(c echo.Context) error {
 		alert := alerts.Alert{}
@@ -52,8 +91,10 @@ func main() {
 		if err := alert.Validate(); err != nil {
 			return c.String(400, fmt.Sprintf("Failed to parse alert: %s", err))
 		}
-		alertList = append(alertList, alert)
-		return c.JSON(200, alertList)
+		if err := alertStore.Add(alert); err != nil {
+			return c.String(400, fmt.Sprintf("Failed to add alert: %s", err))
+		}
+		return c.JSON(200, alertStore.All())
 	})
 	alertGroup.POST("/", func(c echo.Context) error {
 		id, err := func() (int, error) {
@@ -63,9 +104,6 @@ func main() {
 		if err != nil {
 			return c.String(400, "Missing index")
 		}
-		if id >= len(alertList) {
-			return c.String(400, "Index out of range")
-		}
 		alert := alerts.Alert{}
 		if err := c.Bind(&alert); err != nil {
 			return c.String(400, "Failed to bind alert")
@@ -73,8 +111,12 @@ func main() {
 		if err := alert.Validate(); err != nil {
 			return c.String(400, fmt.Sprintf("Failed to parse alert: %s", err))
 		}
-		alertList[id] = alert
-		return c.JSON(200, alertList)
+		if err := alertStore.Replace(id, alert); errors.Is(err, alerts.ErrIndex) {
+			return c.String(400, "Index out of range")
+		} else if err != nil {
+			return c.String(400, fmt.Sprintf("Failed to replace alert: %s", err))
+		}
+		return c.JSON(200, alertStore.All())
 	})
 	alertGroup.DELETE("/", func(c echo.Context) error {
 		index, err := strconv.Atoi(c.QueryParam("id"))
@@ -82,12 +124,68 @@ func main() {
 			return c.String(400, "Failed to parse index")
 		}
 
-		if len(alertList) < index {
+		if err := alertStore.Delete(index); err != nil {
 			return c.String(400, "Index out of range")
 		}
-		alertList = append(alertList[:index], alertList[index+1:]...)
 		return c.String(404, "Alert not found")
 	})
+	// Named lists of stocks that alert scopes refer to
+	watchlistGroup := e.Group("/watchlists")
+	watchlistGroup.GET("/", func(c echo.Context) error {
+		return c.JSON(200, global.Watchlists.All())
+	})
+	watchlistGroup.PUT("/:name", func(c echo.Context) error {
+		var stocks []string
+		if err := c.Bind(&stocks); err != nil {
+			return c.String(400, "Failed to bind watchlist")
+		}
+		global.Watchlists.Set(c.Param("name"), stocks)
+		return c.JSON(200, global.Watchlists.All())
+	})
+	watchlistGroup.DELETE("/:name", func(c echo.Context) error {
+		name := c.Param("name")
+		for _, alert := range alertStore.All() {
+			if slices.Contains(alert.Scope.Watchlists, name) || slices.Contains(alert.Scope.Exclude.Watchlists, name) {
+				return c.String(400, fmt.Sprintf("Watchlist is used by alert %s", alert.Label))
+			}
+		}
+		if !global.Watchlists.Delete(name) {
+			return c.String(404, "Watchlist not found")
+		}
+		return c.JSON(200, global.Watchlists.All())
+	})
+	// Stock names and tickers, available from the cache before upstream answers
+	e.GET("/metadata", func(c echo.Context) error {
+		return c.JSON(200, map[string]any{
+			"updated": global.Metadata.Updated(),
+			"stocks":  global.Metadata.All(),
+		})
+	})
+	// Current market phase
+	e.GET("/session", func(c echo.Context) error {
+		now := global.Entries.Now()
+		holiday, _ := session.Default.Holiday(now)
+		return c.JSON(200, map[string]any{
+			"time":      now,
+			"phase":     session.Default.Phase(now),
+			"holiday":   holiday,
+			"next_open": session.Default.NextOpen(now),
+		})
+	})
+	// Upstream connection health
+	e.GET("/status", func(c echo.Context) error {
+		if r, ok := src.(source.StatusReporter); ok {
+			return c.JSON(200, r.Status())
+		}
+		return c.JSON(200, []lib.ShardStatus{})
+	})
+	// Protocol field registry and the codes seen that it does not cover
+	e.GET("/fields", func(c echo.Context) error {
+		return c.JSON(200, map[string]any{
+			"registry": internal.Fields,
+			"unknown":  internal.Unknown.Snapshot(),
+		})
+	})
 	// Websocket for alerts
 	e.GET("/ws", func(c echo.Context) error {
 		ws, err := websocket.Accept(c.Response().Writer, c.Request(), nil)
@@ -118,15 +216,11 @@ func main() {
 		wsMap[wsIndex] = ws
 		wsIndex++
 		defer delete(wsMap, wsIndex)
+		defer candleSubs.drop(ws)
 
 		// Send notification cache
 		for _, entry := range notificationsCache {
-			_ = wsjson.Write(ctx, ws, map[string]any{
-				"id":     entry.GetIndex(),
-				"data":   entry.ToMap(),
-				"ticker": stockMetadata[entry.GetIndex()].Ticker,
-				"name":   stockMetadata[entry.GetIndex()].Name,
-			})
+			_ = wsjson.Write(ctx, ws, notification(entry))
 		}
 
 		for {
@@ -143,10 +237,22 @@ func main() {
 				if msg, ok := msg["action"]; ok && msg == "pong" {
 					timer.Reset(10 * time.Second)
 				}
+				handleCandleMessage(ws, msg)
 			}
 		}
 	})
-	go stockings(alertList, wsMap)
+	candleRoutes(e)
+	if player != nil {
+		replayRoutes(e, player)
+	}
+	go stockings(src, alertStore, wsMap)
+	if live {
+		go func() {
+			for range time.Tick(time.Minute) {
+				saveTriggers()
+			}
+		}()
+	}
 
 	go func() {
 		if err := e.Start("127.0.0.1:1970"); err != nil {
@@ -157,68 +263,144 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
-	database.SaveAlerts(alertList)
+	database.SaveAlerts(alertStore.All())
+	if err := database.SaveWatchlists(global.Watchlists.All()); err != nil {
+		log.Println("Failed to save watchlists: ", err)
+	}
+	if live {
+		saveTriggers()
+	}
 	println("Exiting")
 }
 
-func stockings(alertList []alerts.Alert, wsMap map[uint]*websocket.Conn) {
-	// Create initial connection to fetch metadata
-	ctx, cancel := context.WithCancel(context.Background())
-	if err := lib.GetStockMetadata(stockMetadata); err != nil {
-		panic(err)
-	}
-	cancel()
+func stockings(src source.DataSource, alertStore *alerts.Store, wsMap map[uint]*websocket.Conn) {
 	stockCh := make(chan models.StockEntry, 100)
 	errCh := make(chan error)
-	ctx, cancel = context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	ids := make([]uint, len(stockMetadata))
-	i := 0
-	for key := range stockMetadata {
-		ids[i] = key
-		i++
-	}
-	for i := 0; i <= len(ids); i += 50 {
-		go func(i int) {
-			for {
-				var err error
-				if (i + 100) > len(ids) {
-					err = lib.GetDataStream(ctx, ids[i:], stockCh)
-				} else {
-					err = lib.GetDataStream(ctx, ids[i:i+50], stockCh)
-				}
-				if err != nil {
-					errCh <- err
-					continue
-				}
+	if clock, ok := src.(source.Clock); ok {
+		global.Entries.SetClock(clock.Now)
+	}
+	refresher := source.NewRefresher(src)
+	// Only upstream metadata is cached, synthetic markets have their own
+	// stocks
+	if _, ok := src.(*source.Cyberstock); ok {
+		refresher.AfterRefresh = func(stocks map[uint]models.StockMetadata, at time.Time) {
+			if err := database.SaveMetadata(database.MetadataCache{Stocks: stocks, Updated: at}); err != nil {
+				log.Println("Failed to cache metadata: ", err)
 			}
-		}(i)
+		}
+	}
+	cache, err := database.LoadMetadata()
+	_, synthetic := src.(*source.Synthetic)
+	_, replaying := src.(*replay.Player)
+	loaded := false
+	if replaying {
+		if _, err := refresher.Refresh(ctx); err == nil {
+			loaded = true
+		} else {
+			log.Println("Failed to read metadata from the recording: ", err)
+		}
+	}
+	// Recordings carry their own listing, so the cache would only turn the
+	// difference between the two into events. Replays fall back to it when
+	// the recording has no SL frames
+	if !loaded && !synthetic && err == nil && len(cache.Stocks) > 0 {
+		log.Printf("Starting from metadata of %d stocks cached at %s", len(cache.Stocks), cache.Updated.Format(time.DateTime))
+		global.Metadata.Load(cache.Stocks, cache.Updated)
+		loaded = true
+	}
+	for failures := 1; !loaded; failures++ {
+		if _, err = refresher.Refresh(ctx); err == nil {
+			break
+		}
+		delay := refresher.Backoff.Delay(failures)
+		log.Printf("Failed to fetch metadata: %s, retrying in %s", err, delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+	go func() {
+		if err := src.Stream(ctx, global.Metadata.Ids(), stockCh); err != nil {
+			errCh <- err
+			return
+		}
+		log.Println("Data source finished")
+	}()
+	eventCh := make(chan models.MetadataEvent, 100)
+	// A recording's listing never changes, and one without SL frames would
+	// only fail to refresh
+	if !replaying {
+		go refresher.Run(ctx, eventCh)
 	}
-	// err = lib.GetDataStream(ctx, ids[0:50], stockCh, errCh)
-	// if err != nil {
-	// 	panic(err)
-	// }
+	alertLoop(ctx, alertStore, wsMap, stockCh, eventCh, errCh)
+}
+
+func alertLoop(ctx context.Context, alertStore *alerts.Store, wsMap map[uint]*websocket.Conn, stockCh chan models.StockEntry, eventCh chan models.MetadataEvent, errCh chan error) {
 	for {
 		select {
 		case stock := <-stockCh:
-			stock = global.Entries.Push(stock)
-			for _, alert := range alertList {
-				if alert.Eval(stock.GetIndex()) {
+			now := global.Entries.Now()
+			stock = global.Entries.PushAt(stock, now)
+			for _, update := range global.Candles.Add(stock, now) {
+				global.Indicators.Feed(update)
+				for _, ws := range candleSubs.subscribers(candleSeries{update.Id, update.Resolution}) {
+					_ = wsjson.Write(ctx, ws, map[string]any{"action": "candle", "update": update})
+				}
+			}
+			alertStore.Range(func(alert alerts.Alert) {
+				if triggers.Check(alert, stock.GetIndex(), now) {
 					notificationsCache[stock.GetIndex()] = stock
+					msg := notification(stock)
+					msg["alert"] = alert.Label
 					for _, ws := range wsMap {
-						_ = wsjson.Write(ctx, ws, map[string]any{
-							"id":     stock.GetIndex(),
-							"data":   stock.ToMap(),
-							"ticker": stockMetadata[stock.GetIndex()].Ticker,
-							"name":   stockMetadata[stock.GetIndex()].Name,
-							"alert":  alert.Label,
-						})
+						_ = wsjson.Write(ctx, ws, msg)
 					}
 				}
+			})
+		case event := <-eventCh:
+			for _, ws := range wsMap {
+				_ = wsjson.Write(ctx, ws, map[string]any{"action": "metadata", "event": event})
 			}
+			alertStore.Range(func(alert alerts.Alert) {
+				if alert.Triggered(event) {
+					msg := map[string]any{"data": map[string]float32{}}
+					if stock, ok := global.Snapshots.Get(event.Id); ok {
+						msg = notification(stock)
+					}
+					msg["id"] = event.Id
+					msg["ticker"] = event.Stock().Ticker
+					msg["name"] = event.Stock().Name
+					msg["alert"] = alert.Label
+					msg["event"] = event.Kind
+					for _, ws := range wsMap {
+						_ = wsjson.Write(ctx, ws, msg)
+					}
+				}
+			})
 		case err := <-errCh:
 			fmt.Println(err)
 			break
 		}
 	}
 }
+
+func saveTriggers() {
+	if err := database.SaveTriggers(triggers.States()); err != nil {
+		log.Println("Failed to save trigger states: ", err)
+	}
+}
+
+// The message sent to /ws clients about a stock
+func notification(stock models.StockEntry) map[string]any {
+	metadata, _ := global.Metadata.Get(stock.GetIndex())
+	return map[string]any{
+		"id":     stock.GetIndex(),
+		"data":   stock.ToMap(),
+		"ticker": metadata.Ticker,
+		"name":   metadata.Name,
+		"phase":  stock.GetPhase(),
+	}
+}
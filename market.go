@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bursa-alert/internal/database"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"os"
+)
+
+// Adds the public holidays in holidays.yaml in the config dir, if it exists
+func loadHolidays() {
+	path := database.ConfigDir() + "/holidays.yaml"
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if err := session.Default.LoadHolidays(path); err != nil {
+		panic(err)
+	}
+}
+
+// Replaces the tick size table with ticks.yaml in the config dir, if it exists
+func loadTicks() {
+	path := database.ConfigDir() + "/ticks.yaml"
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	ticks, err := models.LoadTicks(path)
+	if err != nil {
+		panic(err)
+	}
+	models.Ticks = ticks
+}
+
+// Loads the stock codes of each board from boards.yaml in the config dir, if
+// it exists
+func loadBoards() {
+	path := database.ConfigDir() + "/boards.yaml"
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	boards, err := models.LoadBoards(path)
+	if err != nil {
+		panic(err)
+	}
+	models.BoardCodes = boards
+}
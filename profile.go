@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bursa-alert/internal/database"
+	"bursa-alert/internal/ws"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Connection options for the upstream profile named by BURSA_PROFILE, read
+// from profiles.yaml in the config dir when it exists
+func upstreamOptions() []ws.OptionModifier {
+	profiles := ws.ProfileFile{Version: ws.ProfileVersion}
+	path := database.ConfigDir() + "/profiles.yaml"
+	if _, err := os.Stat(path); err == nil {
+		if profiles, err = ws.LoadProfiles(path); err != nil {
+			panic(err)
+		}
+	}
+	p, err := profiles.Get(os.Getenv("BURSA_PROFILE"))
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("Using upstream profile %s", p.Name)
+	return []ws.OptionModifier{ws.WithProfile(p)}
+}
+
+// Stock ids per upstream connection, from BURSA_SHARD_SIZE
+func shardSize() int {
+	size := os.Getenv("BURSA_SHARD_SIZE")
+	if size == "" {
+		return 50
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 1 {
+		panic("BURSA_SHARD_SIZE must be a positive number")
+	}
+	return n
+}
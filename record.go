@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bursa-alert/internal/database"
+	"bursa-alert/internal/ws"
+	"bursa-alert/lib/models"
+	"bursa-alert/lib/session"
+	"bursa-alert/lib/source"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+// bursa record: subscribes to every ticker and writes the raw upstream frames to disk
+func record(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	dir := flags.String("dir", database.ConfigDir()+"/recordings", "directory to write recordings to")
+	shardSize := flags.Int("shard", shardSize(), "stock ids per upstream connection")
+	maxSize := flags.Int64("max-size", 256, "maximum uncompressed size of each file in MiB")
+	_ = flags.Parse(args)
+
+	rec, err := ws.NewRotatingRecorder(*dir, *maxSize<<20, session.Location)
+	if err != nil {
+		panic(err)
+	}
+	defer rec.Close()
+
+	src := source.NewCyberstock(append(upstreamOptions(), ws.WithRecorder(rec))...)
+	src.Shards.Cap = *shardSize
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	metadata, err := src.Metadata(ctx)
+	if err != nil {
+		panic(err)
+	}
+	ids := make([]uint, 0, len(metadata))
+	for id := range metadata {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	log.Printf("Recording %d stocks to %s", len(ids), *dir)
+
+	stockCh := make(chan models.StockEntry, 100)
+	go func() {
+		for range stockCh {
+		}
+	}()
+	go src.Stream(ctx, ids, stockCh)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	println("Exiting")
+}
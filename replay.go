@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bursa-alert/lib/replay"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bursa replay: runs the server against recordings instead of the live feed
+func newPlayer(args []string) *replay.Player {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := flags.String("speed", "1x", "playback speed, e.g. 1x, 10x or max")
+	_ = flags.Parse(args)
+
+	x, err := parseSpeed(*speed)
+	if err != nil {
+		panic(err)
+	}
+	p, err := replay.New(flags.Args()...)
+	if err != nil {
+		panic(err)
+	}
+	p.SetSpeed(x)
+	return p
+}
+
+func parseSpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	x, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || x < 0 {
+		return 0, fmt.Errorf("%s is not a valid speed", s)
+	}
+	return x, nil
+}
+
+func replayRoutes(e *echo.Echo, p *replay.Player) {
+	group := e.Group("/replay")
+	group.GET("/", func(c echo.Context) error {
+		return c.JSON(200, p.Status())
+	})
+	group.POST("/pause", func(c echo.Context) error {
+		p.Pause()
+		return c.JSON(200, p.Status())
+	})
+	group.POST("/resume", func(c echo.Context) error {
+		p.Resume()
+		return c.JSON(200, p.Status())
+	})
+	group.POST("/speed", func(c echo.Context) error {
+		x, err := parseSpeed(c.QueryParam("x"))
+		if err != nil {
+			return c.String(400, err.Error())
+		}
+		p.SetSpeed(x)
+		return c.JSON(200, p.Status())
+	})
+	group.POST("/seek", func(c echo.Context) error {
+		d, err := time.ParseDuration(c.QueryParam("t"))
+		if err != nil || d < 0 {
+			return c.String(400, "Failed to parse position")
+		}
+		p.Seek(d)
+		return c.JSON(200, p.Status())
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bursa-alert/lib/source"
+	"flag"
+	"time"
+)
+
+// bursa synthetic: runs the server against a generated market
+func newSynthetic(args []string) *source.Synthetic {
+	flags := flag.NewFlagSet("synthetic", flag.ExitOnError)
+	stocks := flags.Int("stocks", 500, "number of generated stocks")
+	seed := flags.Uint64("seed", 1, "random seed")
+	interval := flags.Duration("interval", 200*time.Millisecond, "time between market steps")
+	_ = flags.Parse(args)
+
+	s := source.NewSynthetic(*stocks, *seed)
+	s.Interval = *interval
+	return s
+}